@@ -0,0 +1,403 @@
+package rrd
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"errors"
+)
+
+// an Archive stores the steps of a RrdSet consolidated to a lower resolution
+type Archive struct {
+	// the number of primary steps consolidated into each step of this archive
+	Steps			uint64		`xyzdb:"Steps" bson:"Steps" json:"Steps"`
+	// the number of steps stored by this archive
+	Rows			uint64		`xyzdb:"Rows" bson:"Rows" json:"Rows"`
+	// rrd.Average, rrd.Min, rrd.Max, rrd.Last or rrd.Sum
+	Consolidation		uint8		`xyzdb:"Consolidation" bson:"Consolidation" json:"Consolidation"`
+	// the fraction of unknown primary steps allowed before a consolidated step is unknown
+	Xff			float64		`xyzdb:"Xff" bson:"Xff" json:"Xff"`
+	// the consolidated values, a Gauge Rrd with Interval of Steps * RrdSet.Interval and TotalSteps of Rows
+	// Counter, Derive and Absolute RrdSet archives store the consolidated rate
+	Rrd			Rrd		`xyzdb:"Rrd" bson:"Rrd" json:"Rrd"`
+	// the consolidation of the archive step that is not complete
+	AccStart		*time.Time	`xyzdb:"AccStart" bson:"AccStart" json:"AccStart"`
+	Acc			Series		`xyzdb:"Acc" bson:"Acc" json:"Acc"`
+	AccKnown		[]uint64	`xyzdb:"AccKnown" bson:"AccKnown" json:"AccKnown"`
+}
+
+// a RrdSet takes one update stream and consolidates it into each Archive
+type RrdSet struct {
+	// the primary step, each Archive step is a multiple of Interval
+	Interval		time.Duration	`xyzdb:"Interval" bson:"Interval" json:"Interval"`
+	DataType		uint8		`xyzdb:"DataType" bson:"DataType" json:"DataType"`
+	// receives the updates, primary steps are held until every Archive has consolidated them
+	Primary			Rrd		`xyzdb:"Primary" bson:"Primary" json:"Primary"`
+	Archives		[]*Archive	`xyzdb:"Archives" bson:"Archives" json:"Archives"`
+	// the time archive steps are counted from
+	Origin			*time.Time	`xyzdb:"Origin" bson:"Origin" json:"Origin"`
+	// the start of the first primary step that has not been consolidated
+	NextStep		*time.Time	`xyzdb:"NextStep" bson:"NextStep" json:"NextStep"`
+	// returns the time used by UpdateRrdSet, time.Now when nil
+	Clock			func() time.Time	`xyzdb:"-" bson:"-" json:"-"`
+}
+
+func NewRrdSet(interval time.Duration, dataType uint8, archives ...Archive) (*RrdSet) {
+
+	// create a RrdSet with a primary step of interval
+	// Steps, Rows, Consolidation and Xff are used from each archive
+
+	var set RrdSet
+	set.Interval = interval
+	set.DataType = dataType
+
+	var most_steps uint64 = 1
+
+	for l := range archives {
+
+		var arc Archive
+		arc.Steps = archives[l].Steps
+		if (arc.Steps == 0) {
+			arc.Steps = 1
+		}
+		arc.Rows = archives[l].Rows
+		arc.Consolidation = archives[l].Consolidation
+		arc.Xff = archives[l].Xff
+
+		arc.Rrd.Interval = interval * time.Duration(arc.Steps)
+		arc.Rrd.TotalSteps = arc.Rows
+		arc.Rrd.DataType = Gauge
+		arc.Rrd.Consolidation = arc.Consolidation
+		arc.Rrd.Storage = archives[l].Rrd.Storage
+
+		if (arc.Steps > most_steps) {
+			most_steps = arc.Steps
+		}
+
+		set.Archives = append(set.Archives, &arc)
+
+	}
+
+	set.Primary.Interval = interval
+	set.Primary.DataType = dataType
+	// one more step than the longest archive step so a complete archive step is always available
+	set.Primary.TotalSteps = most_steps + 1
+
+	return &set
+
+}
+
+func UpdateRrdSet(updateDataPoint []*float64, setPtr *RrdSet) (error) {
+
+	// update the RrdSet with the time of execution
+
+	var now = time.Now()
+	if ((*setPtr).Clock != nil) {
+		now = (*setPtr).Clock()
+	}
+
+	return UpdateRrdSetAt(now, updateDataPoint, setPtr)
+
+}
+
+func UpdateRrdSetAt(updateTimeStamp time.Time, updateDataPoint []*float64, setPtr *RrdSet) (error) {
+
+	// update the primary Rrd of the RrdSet
+	// then consolidate each primary step that is complete into each Archive
+	// an error of an Archive update is returned after every Archive is updated
+
+	// the archives are moved with the primary Rrd when rrd.ClockRebase moves it
+	(*setPtr).Primary.rebased = (*setPtr).rebase
+	var err = UpdateAt(updateTimeStamp, updateDataPoint, &(*setPtr).Primary)
+	(*setPtr).Primary.rebased = nil
+	if (err != nil) {
+		return err
+	}
+
+	var primaryPtr = &(*setPtr).Primary
+
+	for l := range (*setPtr).Archives {
+		// an archive step of a gap is unknown like the primary steps of the gap
+		(*setPtr).Archives[l].Rrd.GapPolicy = (*primaryPtr).GapPolicy
+	}
+
+	if ((*primaryPtr).FirstUpdateTs == nil) {
+		return nil
+	}
+
+	// the errors of the Archive updates
+	var archive_err error
+
+	if ((*setPtr).Origin == nil || (*primaryPtr).FirstUpdateTs.Sub((*(*setPtr).Origin)) % (*setPtr).Interval != 0) {
+
+		// this is the first update or the primary Rrd was replaced by an update much newer than the data
+		// archive steps are counted from the first primary step
+
+		for l := range (*setPtr).Archives {
+			archive_err = errors.Join(archive_err, flush_archive((*setPtr).Archives[l]))
+		}
+
+		var origin = (*(*primaryPtr).FirstUpdateTs)
+		var next_step = origin
+		if ((*primaryPtr).Alignment == AlignEpoch) {
+			// archive steps start at multiples of the archive Interval since the Unix epoch in the TimeZone of the Primary Rrd
+			var location, _ = time_zone(primaryPtr)
+			var _, offset = origin.In(location).Zone()
+			origin = time.Unix(int64(-offset), 0)
+		}
+		(*setPtr).Origin = &origin
+		(*setPtr).NextStep = &next_step
+
+	}
+
+	// the start of the step of this update, primary steps before it are complete
+	var current_step_start = (*(*primaryPtr).FirstUpdateTs).Add((*setPtr).Interval * time.Duration(step_at(primaryPtr, updateTimeStamp)))
+
+	if ((*(*setPtr).NextStep).Before((*(*primaryPtr).FirstUpdateTs))) {
+
+		// the primary steps before FirstUpdateTs were removed from the primary Rrd without an update, they are unknown
+		// archive steps that end before FirstUpdateTs are complete
+
+		for l := range (*setPtr).Archives {
+
+			var arc = (*setPtr).Archives[l]
+
+			if (arc.AccStart != nil && (*arc.AccStart).Add(arc.Rrd.Interval).Compare((*(*primaryPtr).FirstUpdateTs)) <= 0) {
+				archive_err = errors.Join(archive_err, flush_archive(arc))
+			}
+
+		}
+
+		*(*setPtr).NextStep = (*(*primaryPtr).FirstUpdateTs)
+
+	}
+
+	var values = make([]float64, (*primaryPtr).DataPoints())
+
+	for ((*(*setPtr).NextStep).Before(current_step_start)) {
+
+		var step = uint64(step_at(primaryPtr, (*(*setPtr).NextStep)))
+
+		for ds := range values {
+
+			if ((*primaryPtr).HasRates() == true) {
+				values[ds], _ = (*primaryPtr).Rate(step, ds)
+			} else {
+				values[ds], _ = (*primaryPtr).Value(step, ds)
+			}
+
+		}
+
+		for l := range (*setPtr).Archives {
+			archive_err = errors.Join(archive_err, add_archive((*setPtr).Archives[l], (*setPtr).Interval, (*(*setPtr).Origin), (*(*setPtr).NextStep), values))
+		}
+
+		*(*setPtr).NextStep = (*(*setPtr).NextStep).Add((*setPtr).Interval)
+
+	}
+
+	return archive_err
+
+}
+
+func (setPtr *RrdSet) rebase(shift time.Duration) {
+
+	// move the archive steps and the steps that are not consolidated by the shift of the primary Rrd
+
+	if ((*setPtr).Origin != nil) {
+		var origin = (*(*setPtr).Origin).Add(shift)
+		(*setPtr).Origin = &origin
+	}
+
+	if ((*setPtr).NextStep != nil) {
+		var next_step = (*(*setPtr).NextStep).Add(shift)
+		(*setPtr).NextStep = &next_step
+	}
+
+	for l := range (*setPtr).Archives {
+
+		var arc = (*setPtr).Archives[l]
+
+		if (arc.AccStart != nil) {
+			var acc_start = (*arc.AccStart).Add(shift)
+			arc.AccStart = &acc_start
+		}
+
+		if (arc.Rrd.FirstUpdateTs != nil) {
+			rebase(&arc.Rrd, shift)
+		}
+
+	}
+
+}
+
+func add_archive(arc *Archive, interval time.Duration, origin time.Time, ts time.Time, values []float64) (error) {
+
+	// consolidate the values of the primary step at ts into the archive step
+	// the error of an archive step that is written to the archive Rrd is returned
+
+	var archive_interval = interval * time.Duration(arc.Steps)
+	var archive_step_start = origin.Add(ts.Sub(origin) / archive_interval * archive_interval)
+
+	var err error
+
+	if (arc.AccStart != nil && (*arc.AccStart).Equal(archive_step_start) == false) {
+		// the archive step of the previous primary step is complete
+		err = flush_archive(arc)
+	}
+
+	if (arc.AccStart == nil) {
+
+		arc.AccStart = &archive_step_start
+		arc.Acc = new_series(uint64(len(values)))
+		arc.AccKnown = make([]uint64, len(values))
+
+	}
+
+	for (len(arc.Acc) < len(values)) {
+		arc.Acc = append(arc.Acc, math.NaN())
+		arc.AccKnown = append(arc.AccKnown, 0)
+	}
+
+	for ds := range values {
+
+		if (math.IsNaN(values[ds])) {
+			continue
+		}
+
+		if (arc.AccKnown[ds] == 0) {
+			arc.Acc[ds] = values[ds]
+		} else {
+			arc.Acc[ds] = consolidate(arc.Consolidation, arc.Acc[ds], values[ds], int64(arc.AccKnown[ds]))
+		}
+
+		arc.AccKnown[ds] += 1
+
+	}
+
+	if (ts.Add(interval).Equal(archive_step_start.Add(archive_interval))) {
+		// this is the last primary step of the archive step
+		err = errors.Join(err, flush_archive(arc))
+	}
+
+	return err
+
+}
+
+func flush_archive(arc *Archive) (error) {
+
+	// write the consolidation of the archive step to the archive Rrd
+
+	if (arc.AccStart == nil) {
+		return nil
+	}
+
+	var values = make([]float64, len(arc.Acc))
+
+	for ds := range arc.Acc {
+
+		// primary steps that were not added are unknown
+		var unknown_fraction = float64(arc.Steps - arc.AccKnown[ds]) / float64(arc.Steps)
+
+		if (arc.AccKnown[ds] == 0 || unknown_fraction > arc.Xff) {
+			values[ds] = math.NaN()
+		} else {
+			values[ds] = arc.Acc[ds]
+		}
+
+	}
+
+	var err = UpdateFloatAt((*arc.AccStart), values, &arc.Rrd)
+	if (err != nil) {
+		if arc.Rrd.Debug { fmt.Println(err) }
+		err = fmt.Errorf("archive step %s of the Archive with interval %s: %w", (*arc.AccStart).String(), arc.Rrd.Interval.String(), err)
+	}
+
+	arc.AccStart = nil
+	arc.Acc = nil
+	arc.AccKnown = nil
+
+	return err
+
+}
+
+func (setPtr *RrdSet) Best(start time.Time, end time.Time) (*Rrd) {
+
+	// return the Rrd of the Archive that has data for the longest part of start to end
+	// the Archive with the highest resolution is returned when more than one has data for the same part
+	// the primary steps from RrdSet.NextStep are not consolidated into any Archive, end is at most NextStep
+	// when no Archive has data from start to end, the Archive with the oldest data is returned
+
+	if ((*setPtr).NextStep != nil && (*(*setPtr).NextStep).Before(end)) {
+		end = (*(*setPtr).NextStep)
+	}
+
+	var best *Archive
+	var best_part time.Duration
+	var oldest *Archive
+
+	for l := range (*setPtr).Archives {
+
+		var arc = (*setPtr).Archives[l]
+
+		if (arc.Rrd.FirstUpdateTs == nil) {
+			continue
+		}
+
+		if (oldest == nil || (*arc.Rrd.FirstUpdateTs).Before((*oldest.Rrd.FirstUpdateTs))) {
+			oldest = arc
+		}
+
+		// the archive has data from FirstUpdateTs to the end of the step of LastUpdate, the step in Acc is not written yet
+		var data_start = (*arc.Rrd.FirstUpdateTs)
+		var data_end = step_time(&arc.Rrd, uint64(step_at(&arc.Rrd, arc.Rrd.LastUpdate))).Add(arc.Rrd.Interval)
+
+		if (data_start.Before(start) == true) {
+			data_start = start
+		}
+
+		if (data_end.After(end) == true) {
+			data_end = end
+		}
+
+		var part = data_end.Sub(data_start)
+		if (part <= 0) {
+			continue
+		}
+
+		if (best == nil || part > best_part || (part == best_part && arc.Rrd.Interval < best.Rrd.Interval)) {
+			best = arc
+			best_part = part
+		}
+
+	}
+
+	if (best != nil) {
+		return &best.Rrd
+	}
+
+	if (oldest != nil) {
+		return &oldest.Rrd
+	}
+
+	return nil
+
+}
+
+func (setPtr *RrdSet) ArchiveOf(interval time.Duration, consolidation uint8) (*Rrd, error) {
+
+	// return the Rrd of the Archive with interval and consolidation
+
+	for l := range (*setPtr).Archives {
+
+		var arc = (*setPtr).Archives[l]
+
+		if (arc.Rrd.Interval == interval && arc.Consolidation == consolidation) {
+			return &arc.Rrd, nil
+		}
+
+	}
+
+	return nil, errors.New("the RrdSet has no Archive with interval " + interval.String() + " and consolidation " + consolidation_string(consolidation))
+
+}
@@ -0,0 +1,146 @@
+package rrd
+
+import (
+	"math"
+	"time"
+	"testing"
+)
+
+func update_test_set(t *testing.T, setPtr *RrdSet, base time.Time, values map[int]float64, last int) {
+
+	// update the RrdSet with the value of each minute since base to last, a minute without a value is not updated
+
+	t.Helper()
+
+	for n := 0; n <= last; n++ {
+
+		var v, found = values[n]
+		if (found == false) {
+			continue
+		}
+
+		var err = UpdateRrdSetAt(base.Add(time.Duration(n) * time.Minute), GetUpdateValues(v), setPtr)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+}
+
+func check_archive(t *testing.T, rrdPtr *Rrd, base time.Time, want []float64) {
+
+	// the steps of the archive start at base and have the values of want, NaN is unknown
+
+	t.Helper()
+
+	if ((*(*rrdPtr).FirstUpdateTs).Equal(base) == false) {
+		t.Fatalf("FirstUpdateTs %s, want %s", (*rrdPtr).FirstUpdateTs, base)
+	}
+
+	for n := range want {
+
+		var v, known = (*rrdPtr).Value(uint64(n), 0)
+		if (known == math.IsNaN(want[n]) || (known == true && math.Abs(v - want[n]) > 1e-9)) {
+			t.Errorf("step %d at %s is %v known %t, want %v", n, step_time(rrdPtr, uint64(n)), v, known, want[n])
+		}
+
+	}
+
+}
+
+func TestRrdSetRollup(t *testing.T) {
+
+	// the primary steps of each archive step are consolidated when the archive step is complete
+
+	var set = NewRrdSet(time.Minute, Gauge, Archive{Steps: 5, Rows: 4, Consolidation: Average}, Archive{Steps: 5, Rows: 4, Consolidation: Max}, Archive{Steps: 5, Rows: 4, Consolidation: Sum})
+	var base = time.Unix(1700000000, 0).Truncate(time.Hour)
+
+	var values = map[int]float64{}
+	for n := 0; n <= 10; n++ {
+		values[n] = float64(n)
+	}
+
+	update_test_set(t, set, base, values, 10)
+
+	var average, _ = set.ArchiveOf(5 * time.Minute, Average)
+	var highest, _ = set.ArchiveOf(5 * time.Minute, Max)
+	var sum, _ = set.ArchiveOf(5 * time.Minute, Sum)
+
+	// the archive step of minute 10 is not complete
+	check_archive(t, average, base, []float64{2, 7, math.NaN(), math.NaN()})
+	check_archive(t, highest, base, []float64{4, 9, math.NaN(), math.NaN()})
+	check_archive(t, sum, base, []float64{10, 35, math.NaN(), math.NaN()})
+
+	var _, err = set.ArchiveOf(time.Hour, Average)
+	if (err == nil) {
+		t.Fatal("ArchiveOf returned an archive that does not exist")
+	}
+
+}
+
+func TestRrdSetXff(t *testing.T) {
+
+	// an archive step is unknown when the fraction of unknown primary steps is more than Xff
+
+	var set = NewRrdSet(time.Minute, Gauge, Archive{Steps: 4, Rows: 4, Consolidation: Average, Xff: 0.5})
+	var base = time.Unix(1700000000, 0).Truncate(time.Hour)
+
+	// 1 of 4 unknown, 3 of 4 unknown, 2 of 4 unknown
+	update_test_set(t, set, base, map[int]float64{0: 10, 2: 30, 3: 40, 4: 50, 8: 90, 9: 100, 12: 130}, 12)
+
+	check_archive(t, &set.Archives[0].Rrd, base, []float64{80.0 / 3, math.NaN(), 95, math.NaN()})
+
+}
+
+func TestRrdSetBest(t *testing.T) {
+
+	// the fine archive has the minutes 24 to 27, the coarse archive has minutes 0 to 24
+	// the coarse step of minutes 25 to 29 is not complete and the primary step of minute 28 is not consolidated
+
+	var set = NewRrdSet(time.Minute, Gauge, Archive{Steps: 1, Rows: 4}, Archive{Steps: 5, Rows: 10})
+	var base = time.Unix(1700000000, 0).Truncate(time.Hour)
+
+	var values = map[int]float64{}
+	for n := 0; n <= 28; n++ {
+		values[n] = float64(n)
+	}
+
+	update_test_set(t, set, base, values, 28)
+
+	var fine = &set.Archives[0].Rrd
+	var coarse = &set.Archives[1].Rrd
+
+	var minute = func(n int) (time.Time) {
+		return base.Add(time.Duration(n) * time.Minute)
+	}
+
+	var tests = []struct {
+		name			string
+		start			int
+		end			int
+		want			*Rrd
+	}{
+		{"only the fine archive has data to end", 25, 28, fine},
+		{"both archives have data from start", 24, 28, fine},
+		{"the fine archive has the longer part of a range it does not have the start of", 22, 28, fine},
+		{"the coarse archive has the longer part", 10, 28, coarse},
+		{"only the coarse archive has data", 5, 20, coarse},
+		{"end is after the consolidated steps", 25, 60, fine},
+	}
+
+	for _, test := range tests {
+
+		var got = set.Best(minute(test.start), minute(test.end))
+		if (got != test.want) {
+			t.Errorf("%s: Best returned the archive with interval %s, want %s", test.name, (*got).Interval, (*test.want).Interval)
+		}
+
+	}
+
+	// no archive has data from start to end
+	if (set.Best(minute(-60), minute(-30)) != coarse) {
+		t.Error("Best of a range without data did not return the archive with the oldest data")
+	}
+
+}
@@ -0,0 +1,194 @@
+package rrd
+
+import (
+	"fmt"
+	"time"
+)
+
+// how an update with a clock regression or a forward jump is handled
+// the time of an update from the same process has a monotonic reading, when the update and LastUpdate both have one the monotonic time since LastUpdate is used
+// 	a wall clock that was set back is not a regression, the update is at LastUpdate plus the monotonic time since LastUpdate
+// 	a wall clock that is ahead of the monotonic time by more than Tolerance was set forward or the system was suspended
+// without a monotonic reading, like a Rrd that was stored and loaded or UpdateAt with a parsed time, only the wall clock is compared
+type ClockPolicy struct {
+	// rrd.ClockReject, rrd.ClockClamp or rrd.ClockRebase for an update before LastUpdate
+	// 	rrd.ClockReject - the update returns *UpdateTooOldError
+	// 	rrd.ClockClamp - the update is at LastUpdate
+	// 	rrd.ClockRebase - FirstUpdateTs and LastUpdate are moved back so the update is at LastUpdate, the data is kept
+	OnBackward		uint8		`xyzdb:"OnBackward" bson:"OnBackward" json:"OnBackward"`
+	// rrd.ClockReject, rrd.ClockClamp or rrd.ClockRebase for an update more than MaxForward after LastUpdate
+	// 	rrd.ClockReject - the update returns *ClockJumpError
+	// 	rrd.ClockClamp - the update is at LastUpdate plus MaxForward
+	// 	rrd.ClockRebase - FirstUpdateTs and LastUpdate are moved forward so the update is at LastUpdate plus the monotonic time, the data is kept
+	OnForward		uint8		`xyzdb:"OnForward" bson:"OnForward" json:"OnForward"`
+	// the longest time between updates that is not a forward jump, 0 has no limit and an update more than TotalSteps * 2 steps after FirstUpdateTs replaces all the data
+	MaxForward		time.Duration	`xyzdb:"MaxForward" bson:"MaxForward" json:"MaxForward"`
+	// the difference between the wall clock and the monotonic clock that is a jump, 0 is a second
+	Tolerance		time.Duration	`xyzdb:"Tolerance" bson:"Tolerance" json:"Tolerance"`
+}
+
+// a clock regression or forward jump passed to Rrd.OnClockJump
+type ClockJump struct {
+	// the time of the update
+	Ts			time.Time
+	// the time the update was stored at, the zero time when it was rejected
+	UpdateTs		time.Time
+	// LastUpdate before the update
+	LastUpdate		time.Time
+	// the wall clock difference from the expected time, negative when the clock went back or the update is before LastUpdate
+	// the expected time is the monotonic time since LastUpdate, or LastUpdate without a monotonic reading
+	Jump			time.Duration
+	// rrd.ClockReject, rrd.ClockClamp, rrd.ClockRebase or rrd.ClockMonotonic
+	Policy			uint8
+}
+
+// returned by UpdateAt when the update is more than ClockPolicy.MaxForward after Rrd.LastUpdate with rrd.ClockReject
+type ClockJumpError struct {
+	Ts			time.Time
+	LastUpdate		time.Time
+	MaxForward		time.Duration
+}
+
+func (e *ClockJumpError) Error() (string) {
+	return "update at " + e.Ts.String() + " is more than MaxForward " + e.MaxForward.String() + " after LastUpdate " + e.LastUpdate.String()
+}
+
+func clock_time(rrdPtr *Rrd, ts time.Time) (time.Time, error) {
+
+	// return the time of the update at ts after the regression or forward jump is handled by Rrd.ClockPolicy
+
+	if ((*rrdPtr).FirstUpdateTs == nil) {
+		return ts, nil
+	}
+
+	var policy = (*rrdPtr).ClockPolicy
+	var last = (*rrdPtr).LastUpdate
+
+	var tolerance = policy.Tolerance
+	if (tolerance == 0) {
+		tolerance = time.Second
+	}
+
+	// the time since the previous update by the wall clock
+	var wall = ts.Round(0).Sub(last.Round(0))
+
+	// the time since the previous update by the monotonic clock
+	var monotonic = has_monotonic(ts) && has_monotonic(last)
+	var elapsed = wall
+	if (monotonic == true) {
+		elapsed = ts.Sub(last)
+	}
+
+	if (elapsed < 0) {
+
+		// the update is before the previous update, by the monotonic clock in this process or by the wall clock
+		// the clock was set back or the Rrd was stored by a clock that was ahead
+		return clock_policy(rrdPtr, policy.OnBackward, ClockJump{Ts: ts, LastUpdate: last, Jump: elapsed}, 0)
+
+	}
+
+	if (monotonic == true && elapsed - wall > tolerance) {
+
+		// the wall clock was set back in this process, the update is at the monotonic time since the previous update
+		var update_ts = last.Add(elapsed)
+
+		clock_jump(rrdPtr, ClockJump{Ts: ts, UpdateTs: update_ts, LastUpdate: last, Jump: wall - elapsed, Policy: ClockMonotonic})
+
+		return update_ts, nil
+
+	}
+
+	if (policy.MaxForward > 0 && wall > policy.MaxForward && (monotonic == false || wall - elapsed > tolerance)) {
+
+		// the clock was set forward, the system was suspended or the Rrd was stored long ago
+		if (monotonic == true) {
+			return clock_policy(rrdPtr, policy.OnForward, ClockJump{Ts: ts, LastUpdate: last, Jump: wall - elapsed}, elapsed)
+		}
+
+		return clock_policy(rrdPtr, policy.OnForward, ClockJump{Ts: ts, LastUpdate: last, Jump: wall}, 0)
+
+	}
+
+	return ts, nil
+
+}
+
+func clock_policy(rrdPtr *Rrd, policy uint8, jump ClockJump, expected time.Duration) (time.Time, error) {
+
+	// handle the jump with policy, expected is the time since LastUpdate the update is at after rrd.ClockRebase
+
+	var forward = jump.Jump > 0
+	var last = jump.LastUpdate.Round(0)
+
+	jump.Policy = policy
+
+	switch policy {
+
+		case ClockClamp:
+
+			jump.UpdateTs = jump.LastUpdate
+			if (forward == true) {
+				jump.UpdateTs = last.Add((*rrdPtr).ClockPolicy.MaxForward)
+			}
+
+		case ClockRebase:
+
+			var shift = jump.Ts.Round(0).Sub(last) - expected
+			if ((*rrdPtr).Alignment == AlignEpoch) {
+				// the steps stay aligned, the update is later in its step than expected
+				shift = shift - ((shift % (*rrdPtr).Interval) + (*rrdPtr).Interval) % (*rrdPtr).Interval
+			}
+
+			rebase(rrdPtr, shift)
+			jump.UpdateTs = jump.Ts
+
+		default:
+
+			jump.Policy = ClockReject
+			clock_jump(rrdPtr, jump)
+
+			if (forward == true) {
+				return time.Time{}, &ClockJumpError{Ts: jump.Ts, LastUpdate: jump.LastUpdate, MaxForward: (*rrdPtr).ClockPolicy.MaxForward}
+			}
+
+			return time.Time{}, &UpdateTooOldError{Ts: jump.Ts, LastUpdate: jump.LastUpdate}
+
+	}
+
+	clock_jump(rrdPtr, jump)
+
+	return jump.UpdateTs, nil
+
+}
+
+func rebase(rrdPtr *Rrd, shift time.Duration) {
+
+	// move the steps of the Rrd by shift, the values are not changed
+
+	var first_update_ts = (*(*rrdPtr).FirstUpdateTs).Round(0).Add(shift)
+	(*rrdPtr).FirstUpdateTs = &first_update_ts
+	(*rrdPtr).LastUpdate = (*rrdPtr).LastUpdate.Round(0).Add(shift)
+
+	if ((*rrdPtr).rebased != nil) {
+		(*rrdPtr).rebased(shift)
+	}
+
+}
+
+func clock_jump(rrdPtr *Rrd, jump ClockJump) {
+
+	if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "clock jump of " + jump.Jump.String() + " at " + jump.Ts.String() + colorCodeReset) }
+
+	if ((*rrdPtr).OnClockJump != nil) {
+		(*rrdPtr).OnClockJump(jump)
+	}
+
+}
+
+func has_monotonic(t time.Time) (bool) {
+
+	// Round(0) removes the monotonic reading
+
+	return t != t.Round(0)
+
+}
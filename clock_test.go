@@ -0,0 +1,97 @@
+package rrd
+
+import (
+	"time"
+	"errors"
+	"testing"
+)
+
+func TestClockRejectsOlderMonotonicUpdate(t *testing.T) {
+
+	// both updates have a monotonic reading from time.Now
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 10, DataType: Gauge}
+
+	var now = time.Now()
+	if (has_monotonic(now) == false) {
+		t.Fatal("time.Now has no monotonic reading")
+	}
+
+	var err = UpdateFloatAt(now, []float64{1}, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	var jumps []ClockJump
+	r.OnClockJump = func(jump ClockJump) {
+		jumps = append(jumps, jump)
+	}
+
+	err = UpdateFloatAt(now.Add(-3 * time.Second), []float64{2}, &r)
+
+	var too_old *UpdateTooOldError
+	if (errors.As(err, &too_old) == false) {
+		t.Fatalf("an update 3s before LastUpdate returned %v, want *UpdateTooOldError", err)
+	}
+
+	if (r.LastUpdate.Equal(now) == false) {
+		t.Fatalf("LastUpdate moved to %s", r.LastUpdate)
+	}
+
+	if (len(jumps) != 1 || jumps[0].Policy != ClockReject || jumps[0].Jump != -3 * time.Second) {
+		t.Fatalf("OnClockJump was called with %+v", jumps)
+	}
+
+	if v, known := r.Value(0, 0); known == false || v != 1 {
+		t.Fatalf("step 0 is %f %t, want 1", v, known)
+	}
+
+}
+
+func TestClockClampsOlderMonotonicUpdate(t *testing.T) {
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 10, DataType: Gauge}
+	r.ClockPolicy.OnBackward = ClockClamp
+
+	var now = time.Now()
+
+	var err = UpdateFloatAt(now, []float64{1}, &r)
+	if (err == nil) {
+		err = UpdateFloatAt(now.Add(-3 * time.Second), []float64{3}, &r)
+	}
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	if (r.LastUpdate.Equal(now) == false) {
+		t.Fatalf("LastUpdate is %s, want %s", r.LastUpdate, now)
+	}
+
+	// the clamped update is averaged into the step of LastUpdate
+	if v, _ := r.Value(0, 0); v != 2 {
+		t.Fatalf("step 0 is %f, want 2", v)
+	}
+
+}
+
+func TestClockRejectsOlderWallUpdate(t *testing.T) {
+
+	// Round(0) removes the monotonic reading like a time that was stored and loaded
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 10, DataType: Gauge}
+
+	var now = time.Now().Round(0)
+
+	var err = UpdateFloatAt(now, []float64{1}, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	err = UpdateFloatAt(now.Add(-time.Millisecond), []float64{2}, &r)
+
+	var too_old *UpdateTooOldError
+	if (errors.As(err, &too_old) == false) {
+		t.Fatalf("an older update returned %v, want *UpdateTooOldError", err)
+	}
+
+}
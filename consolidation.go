@@ -0,0 +1,138 @@
+package rrd
+
+import (
+	"maps"
+	"time"
+	"errors"
+	"slices"
+)
+
+// Rrd with the same Interval, TotalSteps and DataType that each store a different Consolidation of the same updates
+type ConsolidationSet struct {
+	Rrds			map[uint8]*Rrd	`xyzdb:"Rrds" bson:"Rrds" json:"Rrds"`
+}
+
+func consolidate(consolidation uint8, existing float64, v float64, count int64) (float64) {
+
+	// return existing consolidated with v
+	// count is the number of values consolidated in existing
+
+	switch consolidation {
+		case Min:
+			if (v < existing) {
+				return v
+			}
+			return existing
+		case Max:
+			if (v > existing) {
+				return v
+			}
+			return existing
+		case Last:
+			return v
+		case Sum:
+			return existing + v
+	}
+
+	// Average
+
+	if (count < 1) {
+		return v
+	}
+
+	// multiply the count with the existing value
+	var avg = float64(count) * existing
+
+	// add this value
+	avg += v
+
+	// then divide by the count of this value to get the new average
+	return avg / float64(count + 1)
+
+}
+
+func consolidation_string(consolidation uint8) (string) {
+
+	switch consolidation {
+		case Min:
+			return "MIN"
+		case Max:
+			return "MAX"
+		case Last:
+			return "LAST"
+		case Sum:
+			return "SUM"
+	}
+
+	return "AVERAGE"
+
+}
+
+func NewConsolidationSet(template Rrd, consolidations ...uint8) (*ConsolidationSet) {
+
+	// create a ConsolidationSet with a Rrd for each consolidation
+	// Interval, TotalSteps, DataType and Storage are copied from template
+
+	var set ConsolidationSet
+	set.Rrds = make(map[uint8]*Rrd, len(consolidations))
+
+	for l := range consolidations {
+
+		var r Rrd
+		r.Interval = template.Interval
+		r.TotalSteps = template.TotalSteps
+		r.DataType = template.DataType
+		r.Storage = template.Storage
+		r.Clock = template.Clock
+		r.OnClockJump = template.OnClockJump
+		r.Debug = template.Debug
+		r.Consolidation = consolidations[l]
+		r.Weighting = template.Weighting
+		r.Alignment = template.Alignment
+		r.TimeZone = template.TimeZone
+		r.GapPolicy = template.GapPolicy
+		r.ClockPolicy = template.ClockPolicy
+
+		set.Rrds[consolidations[l]] = &r
+
+	}
+
+	return &set
+
+}
+
+func UpdateConsolidationSet(updateDataPoint []*float64, setPtr *ConsolidationSet) (error) {
+
+	// update every Rrd of the ConsolidationSet with the time of execution
+	// the time is from the Clock of the Rrd with the lowest consolidation so it is the same Clock with any map order
+
+	var now = time.Now()
+
+	var consolidations = slices.Sorted(maps.Keys((*setPtr).Rrds))
+	if (len(consolidations) > 0) {
+		now = rrd_now((*setPtr).Rrds[consolidations[0]])
+	}
+
+	return UpdateConsolidationSetAt(now, updateDataPoint, setPtr)
+
+}
+
+func UpdateConsolidationSetAt(updateTimeStamp time.Time, updateDataPoint []*float64, setPtr *ConsolidationSet) (error) {
+
+	// update every Rrd of the ConsolidationSet with the same update
+	// the Rrds are updated in the order of their consolidation so the errors are in the same order with any map order
+
+	var errs []error
+
+	for _, consolidation := range slices.Sorted(maps.Keys((*setPtr).Rrds)) {
+
+		var err = UpdateAt(updateTimeStamp, updateDataPoint, (*setPtr).Rrds[consolidation])
+		if (err != nil) {
+			errs = append(errs, err)
+		}
+
+	}
+
+	return errors.Join(errs...)
+
+}
@@ -0,0 +1,349 @@
+package rrd
+
+import (
+	"io"
+	"fmt"
+	"iter"
+	"math"
+	"time"
+	"strconv"
+	"strings"
+	"encoding/json"
+	"text/tabwriter"
+)
+
+type DumpOptions struct {
+	// rrd.DumpTable, rrd.DumpJSON or rrd.DumpHuman
+	// 	rrd.DumpTable - a column for the timestamp and each data point, then the rate of each data point
+	// 	rrd.DumpJSON - a JSON object with a timestamp, values and rates for each step
+	// 	rrd.DumpHuman - a line for each step with units from Bytes_to_size_string
+	Format			uint8
+	// the digits after the decimal point, nil is 2 digits and 0 is integers
+	// a negative Precision is the fewest digits that represent each value exactly
+	Precision		*int
+}
+
+// the rrd.DumpJSON object
+type dump_json struct {
+	DataType		string		`json:"data_type"`
+	Interval		string		`json:"interval"`
+	TotalSteps		uint64		`json:"total_steps"`
+	FirstUpdateTs		*time.Time	`json:"first_update_ts"`
+	LastUpdate		*time.Time	`json:"last_update"`
+	Names			[]string	`json:"names"`
+	LastUpdateDataPoint	[]*json.Number	`json:"last_update_data_point"`
+	Steps			[]dump_json_step	`json:"steps"`
+}
+
+type dump_json_step struct {
+	Timestamp		time.Time	`json:"timestamp"`
+	Values			[]*json.Number	`json:"values"`
+	Rates			[]*json.Number	`json:"rates,omitempty"`
+}
+
+func DumpTo(w io.Writer, rrdPtr *Rrd, opts DumpOptions) (error) {
+
+	// write the configuration and each step of the Rrd to w, each step is labeled with the time it starts
+	// unknown values are nil, or null with rrd.DumpJSON
+
+	var precision = 2
+	if (opts.Precision != nil) {
+		precision = (*opts.Precision)
+	}
+
+	switch opts.Format {
+		case DumpTable:
+			return dump_table(w, rrdPtr, precision)
+		case DumpJSON:
+			return dump_json_to(w, rrdPtr, precision)
+		case DumpHuman:
+			return dump_human(w, rrdPtr, precision)
+	}
+
+	return fmt.Errorf("DumpOptions.Format %d is not rrd.DumpTable, rrd.DumpJSON or rrd.DumpHuman", opts.Format)
+
+}
+
+func dump_table(w io.Writer, rrdPtr *Rrd, precision int) (error) {
+
+	if (rrdPtr == nil) {
+		_, err := io.WriteString(w, "rrdPtr is nil.\n")
+		return err
+	}
+
+	var names = display_names(rrdPtr)
+	var rates = (*rrdPtr).HasRates()
+
+	var t = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(t, "%s Rrd, Interval %s, TotalSteps %d\n", data_type_string((*rrdPtr).DataType), (*rrdPtr).Interval.String(), (*rrdPtr).TotalSteps)
+
+	if ((*rrdPtr).FirstUpdateTs == nil) {
+		fmt.Fprintln(t, "rrdPtr.FirstUpdateTs is nil, still waiting on first rrd.Update.")
+		return t.Flush()
+	}
+
+	fmt.Fprintf(t, "FirstUpdateTs %s, LastUpdate %s, CurrentAvgCount %d\n\n", (*(*rrdPtr).FirstUpdateTs).Format(time.RFC3339Nano), (*rrdPtr).LastUpdate.Format(time.RFC3339Nano), (*rrdPtr).CurrentAvgCount)
+
+	// the header
+	var columns = []string{"timestamp"}
+	columns = append(columns, names...)
+	if (rates == true) {
+		for e := range names {
+			columns = append(columns, names[e] + "/s")
+		}
+	}
+	fmt.Fprintln(t, strings.Join(columns, "\t"))
+
+	columns = []string{"last update"}
+	for e := range names {
+		columns = append(columns, dump_pointer((*rrdPtr).LastUpdateDataPoint, e, precision))
+	}
+	if (rates == true) {
+		// the same columns as the steps so tabwriter aligns the rates
+		for range names {
+			columns = append(columns, "")
+		}
+	}
+	fmt.Fprintln(t, strings.Join(columns, "\t"))
+
+	// a row for each step, then the rates are added to the rows
+	var rows [][]string
+
+	for ts, values := range (*rrdPtr).Steps() {
+
+		columns = []string{ts.Format(time.RFC3339Nano)}
+
+		for e := range names {
+			var v, known = dump_value(values, e)
+			columns = append(columns, dump_float(v, known, precision))
+		}
+
+		rows = append(rows, columns)
+
+	}
+
+	if (rates == true) {
+
+		var n = 0
+		for _, values := range (*rrdPtr).Rates() {
+
+			for e := range names {
+				var v, known = dump_value(values, e)
+				rows[n] = append(rows[n], dump_float(v, known, precision))
+			}
+
+			n++
+
+		}
+
+	}
+
+	for n := range rows {
+		fmt.Fprintln(t, strings.Join(rows[n], "\t"))
+	}
+
+	return t.Flush()
+
+}
+
+func dump_json_to(w io.Writer, rrdPtr *Rrd, precision int) (error) {
+
+	if (rrdPtr == nil) {
+		_, err := io.WriteString(w, "null\n")
+		return err
+	}
+
+	var d dump_json
+	d.DataType = data_type_string((*rrdPtr).DataType)
+	d.Interval = (*rrdPtr).Interval.String()
+	d.TotalSteps = (*rrdPtr).TotalSteps
+	d.Names = display_names(rrdPtr)
+	d.LastUpdateDataPoint = make([]*json.Number, len(d.Names))
+	d.Steps = []dump_json_step{}
+
+	if ((*rrdPtr).FirstUpdateTs != nil) {
+
+		var first_update_ts = (*(*rrdPtr).FirstUpdateTs)
+		var last_update = (*rrdPtr).LastUpdate
+		d.FirstUpdateTs = &first_update_ts
+		d.LastUpdate = &last_update
+
+		for e := range d.LastUpdateDataPoint {
+			if (e < len((*rrdPtr).LastUpdateDataPoint) && (*rrdPtr).LastUpdateDataPoint[e] != nil) {
+				d.LastUpdateDataPoint[e] = dump_number((*(*rrdPtr).LastUpdateDataPoint[e]), true, precision)
+			}
+		}
+
+		for ts, values := range (*rrdPtr).Steps() {
+
+			var step = dump_json_step{Timestamp: ts, Values: make([]*json.Number, len(d.Names))}
+
+			for e := range d.Names {
+				var v, known = dump_value(values, e)
+				step.Values[e] = dump_number(v, known, precision)
+			}
+
+			d.Steps = append(d.Steps, step)
+
+		}
+
+		if ((*rrdPtr).HasRates() == true) {
+
+			var n = 0
+			for _, values := range (*rrdPtr).Rates() {
+
+				d.Steps[n].Rates = make([]*json.Number, len(d.Names))
+
+				for e := range d.Names {
+					var v, known = dump_value(values, e)
+					d.Steps[n].Rates[e] = dump_number(v, known, precision)
+				}
+
+				n++
+
+			}
+
+		}
+
+	}
+
+	var encoder = json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+
+	return encoder.Encode(d)
+
+}
+
+func dump_human(w io.Writer, rrdPtr *Rrd, precision int) (error) {
+
+	if (rrdPtr == nil) {
+		_, err := io.WriteString(w, "rrdPtr is nil.\n")
+		return err
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s Rrd with %d steps of %s\n", data_type_string((*rrdPtr).DataType), (*rrdPtr).TotalSteps, (*rrdPtr).Interval.String())
+
+	if ((*rrdPtr).FirstUpdateTs == nil) {
+		b.WriteString("rrdPtr.FirstUpdateTs is nil, still waiting on first rrd.Update.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	var names = display_names(rrdPtr)
+
+	fmt.Fprintf(&b, "first update %s, last update %s\n", (*(*rrdPtr).FirstUpdateTs).Format(time.DateTime), (*rrdPtr).LastUpdate.Format(time.DateTime))
+	b.WriteString("last update values:\n")
+
+	for e := range names {
+
+		if (e < len((*rrdPtr).LastUpdateDataPoint) && (*rrdPtr).LastUpdateDataPoint[e] != nil) {
+			fmt.Fprintf(&b, "\t%s %s\n", names[e], size_string((*(*rrdPtr).LastUpdateDataPoint[e]), precision))
+		} else {
+			fmt.Fprintf(&b, "\t%s nil\n", names[e])
+		}
+
+	}
+
+	dump_human_steps(&b, names, "values:\n", "", precision, (*rrdPtr).Steps())
+
+	if ((*rrdPtr).HasRates() == true) {
+		dump_human_steps(&b, names, "rates:\n", "/s", precision, (*rrdPtr).Rates())
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+
+}
+
+func dump_human_steps(b *strings.Builder, names []string, title string, unit string, precision int, steps iter.Seq2[time.Time, []float64]) {
+
+	// write a line for each step with the value of each data point
+
+	b.WriteString(title)
+
+	for ts, values := range steps {
+
+		var columns = make([]string, len(names))
+
+		for e := range names {
+
+			var v, known = dump_value(values, e)
+			if (known == true) {
+				columns[e] = names[e] + " " + size_string(v, precision) + unit
+			} else {
+				columns[e] = names[e] + " nil"
+			}
+
+		}
+
+		fmt.Fprintf(b, "\t%s\t%s\n", ts.Format(time.DateTime), strings.Join(columns, ", "))
+
+	}
+
+}
+
+func display_names(rrdPtr *Rrd) ([]string) {
+
+	// return the name of each data point, a data point without a name is ds and the index like ds0
+
+	var names = (*rrdPtr).Names()
+
+	for e := range names {
+		if (names[e] == "") {
+			names[e] = "ds" + strconv.Itoa(e)
+		}
+	}
+
+	return names
+
+}
+
+func dump_value(values []float64, e int) (float64, bool) {
+
+	// the value of data point e of a step from Steps or Rates, a name without a data point is unknown
+
+	if (e >= len(values)) {
+		return math.NaN(), false
+	}
+
+	return values[e], !math.IsNaN(values[e])
+
+}
+
+func dump_float(v float64, known bool, precision int) (string) {
+
+	if (known == false) {
+		return "nil"
+	}
+
+	return strconv.FormatFloat(v, 'f', precision, 64)
+
+}
+
+func dump_pointer(values []*float64, e int, precision int) (string) {
+
+	if (e >= len(values) || values[e] == nil) {
+		return "nil"
+	}
+
+	return dump_float((*values[e]), true, precision)
+
+}
+
+func dump_number(v float64, known bool, precision int) (*json.Number) {
+
+	// JSON has no NaN or Inf, they are null
+
+	if (known == false || math.IsNaN(v) || math.IsInf(v, 0)) {
+		return nil
+	}
+
+	var n = json.Number(strconv.FormatFloat(v, 'f', precision, 64))
+
+	return &n
+
+}
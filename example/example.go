@@ -22,6 +22,7 @@ func main() {
 	if_rrd.Interval = time.Second
 	if_rrd.TotalSteps = 10
 	if_rrd.DataType = rrd.Counter
+	if_rrd.DataSources = []rrd.DataSource{{Name: "in"}, {Name: "out"}}
 	if_rrd.Debug = true
 
 	var update_count = 0
@@ -69,8 +70,7 @@ func main() {
 			return 1
 		})
 
-		// must be []any to work with rrd.GetUpdateValues
-		var if_counter = []any{float64(0), float64(0)}
+		var if_counter = map[string]float64{"in": 0, "out": 0}
 		var if_name string
 
 		for e := range s {
@@ -94,8 +94,8 @@ func main() {
 					continue
 				}
 
-				if_counter[0] = float64(b_in)
-				if_counter[1] = float64(b_out)
+				if_counter["in"] = float64(b_in)
+				if_counter["out"] = float64(b_out)
 
 				if_name = strings.TrimSuffix(n[0], ":")
 
@@ -112,10 +112,15 @@ func main() {
 
 		fmt.Printf("\x1b[34m%s\x1b[0m\n", if_name)
 
-		rrd.Update(rrd.GetUpdateValues(if_counter...), &if_rrd)
+		err = rrd.UpdateMap(if_counter, &if_rrd)
+		if (err != nil) {
+			fmt.Println(err)
+		}
 
 		rrd.Dump(&if_rrd)
 
+		fmt.Println("average in", rrd.Bytes_to_size_string(rrd.AvgByName("in", &if_rrd)) + "/s", "average out", rrd.Bytes_to_size_string(rrd.AvgByName("out", &if_rrd)) + "/s")
+
 		time.Sleep(time.Millisecond * 500)
 
 		update_count += 1
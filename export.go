@@ -0,0 +1,345 @@
+package rrd
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"encoding/csv"
+	"encoding/json"
+)
+
+// ExportCSV and ExportNDJSON write a record for each data source of each step from FirstUpdateTs to the step that contains LastUpdate
+// the timestamp is the start of the step, a data source without a name is named ds and the index like ds0
+// the value is the stored value and the rate is the rate per second of a Counter, Derive or Absolute Rrd, unknown is empty in CSV and null in NDJSON
+
+var (
+	// returned when a CSV or NDJSON record is not valid
+	ErrInvalidRecord = errors.New("invalid record")
+)
+
+// a NDJSON record
+type export_record struct {
+	Timestamp		time.Time	`json:"timestamp"`
+	Name			string		`json:"name"`
+	Value			*float64	`json:"value"`
+	Rate			*float64	`json:"rate,omitempty"`
+}
+
+func ExportCSV(w io.Writer, rrdPtr *Rrd) (error) {
+
+	// write the steps of the Rrd as CSV with the header timestamp,name,value,rate
+
+	var c = csv.NewWriter(w)
+
+	var err = c.Write([]string{"timestamp", "name", "value", "rate"})
+	if (err != nil) {
+		return err
+	}
+
+	err = export_records(rrdPtr, func(record export_record) (error) {
+		return c.Write([]string{record.Timestamp.Format(time.RFC3339Nano), record.Name, export_float(record.Value), export_float(record.Rate)})
+	})
+	if (err != nil) {
+		return err
+	}
+
+	c.Flush()
+
+	return c.Error()
+
+}
+
+func ExportNDJSON(w io.Writer, rrdPtr *Rrd) (error) {
+
+	// write the steps of the Rrd as a JSON object on each line
+	// {"timestamp":"2006-01-02T15:04:05Z","name":"in","value":1024,"rate":17.06}
+
+	var encoder = json.NewEncoder(w)
+
+	return export_records(rrdPtr, func(record export_record) (error) {
+		return encoder.Encode(record)
+	})
+
+}
+
+func export_records(rrdPtr *Rrd, write func(export_record) (error)) (error) {
+
+	// call write with the record of each data source of each step
+
+	if ((*rrdPtr).FirstUpdateTs == nil) {
+		return nil
+	}
+
+	var indexes, _, _ = fetch_indexes(rrdPtr, nil)
+	var names = display_names(rrdPtr)
+
+	var values = fetch(rrdPtr, (*(*rrdPtr).FirstUpdateTs), (*rrdPtr).LastUpdate, indexes, names, false)
+
+	var rates *FetchResult
+	if ((*rrdPtr).HasRates() == true) {
+		rates = fetch(rrdPtr, (*(*rrdPtr).FirstUpdateTs), (*rrdPtr).LastUpdate, indexes, names, true)
+	}
+
+	for n := range values.Timestamps {
+
+		for i := range names {
+
+			var record = export_record{Timestamp: values.Timestamps[n], Name: names[i], Value: values.Values[n][i]}
+			if (rates != nil) {
+				record.Rate = rates.Values[n][i]
+			}
+
+			var err = write(record)
+			if (err != nil) {
+				return err
+			}
+
+		}
+
+	}
+
+	return nil
+
+}
+
+func export_float(v *float64) (string) {
+
+	if (v == nil) {
+		return ""
+	}
+
+	return strconv.FormatFloat((*v), 'g', -1, 64)
+
+}
+
+func ImportCSV(r io.Reader, rrdPtr *Rrd) (error) {
+
+	// update the Rrd with the values of a CSV written by ExportCSV
+	// the Rrd must have the Interval, TotalSteps and DataType of the exported Rrd
+	// the values of each timestamp are one update at the timestamp, the rates are calculated again by the updates
+	// records must be in time order, a name that is not in Rrd.DataSources is added as a new data point in the order of the records
+	// a name like ds0 of a data point without a name is the data point at the index
+	// the columns are found by the header, timestamp, name and value are required and rate is not used
+
+	var c = csv.NewReader(r)
+	c.FieldsPerRecord = -1
+
+	var header, err = c.Read()
+	if (err != nil) {
+		return fmt.Errorf("%w, the CSV has no header: %w", ErrInvalidRecord, err)
+	}
+
+	var columns = map[string]int{"timestamp": -1, "name": -1, "value": -1}
+	for n := range header {
+		if _, ok := columns[header[n]]; ok == true {
+			columns[header[n]] = n
+		}
+	}
+
+	for name, n := range columns {
+		if (n == -1) {
+			return fmt.Errorf("%w, the CSV header has no %s column", ErrInvalidRecord, name)
+		}
+	}
+
+	var i = &import_state{rrdPtr: rrdPtr}
+	var line = 1
+
+	for {
+
+		var fields, read_err = c.Read()
+		if (read_err == io.EOF) {
+			break
+		} else if (read_err != nil) {
+			return read_err
+		}
+
+		line += 1
+
+		if (len(fields) < len(header)) {
+			return fmt.Errorf("%w, line %d has %d fields and the header has %d", ErrInvalidRecord, line, len(fields), len(header))
+		}
+
+		var ts, ts_err = time.Parse(time.RFC3339Nano, fields[columns["timestamp"]])
+		if (ts_err != nil) {
+			return fmt.Errorf("%w, line %d timestamp: %w", ErrInvalidRecord, line, ts_err)
+		}
+
+		var v = math.NaN()
+		if (fields[columns["value"]] != "") {
+
+			var parse_err error
+			v, parse_err = strconv.ParseFloat(fields[columns["value"]], 64)
+			if (parse_err != nil) {
+				return fmt.Errorf("%w, line %d value: %w", ErrInvalidRecord, line, parse_err)
+			}
+
+		}
+
+		err = i.add(ts, fields[columns["name"]], v)
+		if (err != nil) {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+	}
+
+	return i.flush()
+
+}
+
+func ImportNDJSON(r io.Reader, rrdPtr *Rrd) (error) {
+
+	// ImportCSV with NDJSON written by ExportNDJSON
+
+	var decoder = json.NewDecoder(r)
+	var i = &import_state{rrdPtr: rrdPtr}
+	var line = 0
+
+	for {
+
+		var record export_record
+
+		var err = decoder.Decode(&record)
+		if (err == io.EOF) {
+			break
+		}
+
+		line += 1
+
+		if (err != nil) {
+			return fmt.Errorf("%w, record %d: %w", ErrInvalidRecord, line, err)
+		}
+
+		if (record.Timestamp.IsZero() == true) {
+			return fmt.Errorf("%w, record %d has no timestamp", ErrInvalidRecord, line)
+		}
+
+		var v = math.NaN()
+		if (record.Value != nil) {
+			v = (*record.Value)
+		}
+
+		err = i.add(record.Timestamp, record.Name, v)
+		if (err != nil) {
+			return fmt.Errorf("record %d: %w", line, err)
+		}
+
+	}
+
+	return i.flush()
+
+}
+
+// the values of the timestamp that is updated next
+type import_state struct {
+	rrdPtr			*Rrd
+	ts			time.Time
+	values			[]float64
+}
+
+func (iPtr *import_state) add(ts time.Time, name string, v float64) (error) {
+
+	// add the value of name at ts, the values of the previous timestamp are updated when ts is newer
+
+	if ((*iPtr).values != nil && ts.Equal((*iPtr).ts) == false) {
+
+		if (ts.Before((*iPtr).ts)) {
+			return fmt.Errorf("%w, %s is before %s", ErrInvalidRecord, ts.Format(time.RFC3339Nano), (*iPtr).ts.Format(time.RFC3339Nano))
+		}
+
+		var err = (*iPtr).flush()
+		if (err != nil) {
+			return err
+		}
+
+	}
+
+	if ((*iPtr).values == nil) {
+		(*iPtr).ts = ts
+		(*iPtr).values = []float64{}
+	}
+
+	var rrdPtr = (*iPtr).rrdPtr
+
+	var e, found = (*rrdPtr).Index(name)
+	if (found == false) {
+		e, found = (*iPtr).unnamed_index(name)
+	}
+
+	if (found == false) {
+
+		// name the data points that exist or have a value at the timestamp then add the new data point
+		for (len((*rrdPtr).DataSources) < max((*rrdPtr).DataPoints(), len((*iPtr).values))) {
+			(*rrdPtr).DataSources = append((*rrdPtr).DataSources, DataSource{})
+		}
+
+		e = len((*rrdPtr).DataSources)
+		(*rrdPtr).DataSources = append((*rrdPtr).DataSources, DataSource{Name: name})
+
+	}
+
+	for (len((*iPtr).values) <= e) {
+		(*iPtr).values = append((*iPtr).values, math.NaN())
+	}
+
+	(*iPtr).values[e] = v
+
+	return nil
+
+}
+
+func (iPtr *import_state) unnamed_index(name string) (int, bool) {
+
+	// return the index of a name like ds0 that was exported for a data point without a name
+	// the data point must not have a name and must exist or be the next new data point
+
+	var rrdPtr = (*iPtr).rrdPtr
+
+	var digits, cut = strings.CutPrefix(name, "ds")
+	if (cut == false) {
+		return -1, false
+	}
+
+	var e, err = strconv.Atoi(digits)
+	if (err != nil || e < 0 || strconv.Itoa(e) != digits) {
+		return -1, false
+	}
+
+	if (e < len((*rrdPtr).DataSources) && (*rrdPtr).DataSources[e].Name != "") {
+		return -1, false
+	}
+
+	if (e > max((*rrdPtr).DataPoints(), len((*rrdPtr).DataSources), len((*iPtr).values))) {
+		return -1, false
+	}
+
+	return e, true
+
+}
+
+func (iPtr *import_state) flush() (error) {
+
+	// update the Rrd with the values of the timestamp
+	// a timestamp without a known value is not updated
+
+	var values = (*iPtr).values
+	(*iPtr).values = nil
+
+	var known = false
+	for e := range values {
+		if (math.IsNaN(values[e]) == false) {
+			known = true
+		}
+	}
+
+	if (known == false) {
+		return nil
+	}
+
+	return UpdateFloatAt((*iPtr).ts, values, (*iPtr).rrdPtr)
+
+}
@@ -0,0 +1,122 @@
+package rrd
+
+import (
+	"io"
+	"time"
+	"bytes"
+	"testing"
+)
+
+func export_round_trip(t *testing.T, rrdPtr *Rrd, export func(io.Writer, *Rrd) (error), import_records func(io.Reader, *Rrd) (error), importedPtr *Rrd) {
+
+	// export the Rrd and import it into importedPtr
+
+	t.Helper()
+
+	var b bytes.Buffer
+
+	var err = export(&b, rrdPtr)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	err = import_records(&b, importedPtr)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+}
+
+func check_export_timestamps(t *testing.T, wantPtr *Rrd, gotPtr *Rrd) {
+
+	// the steps of gotPtr start at the same timestamps as wantPtr
+
+	t.Helper()
+
+	if ((*(*gotPtr).FirstUpdateTs).Equal((*(*wantPtr).FirstUpdateTs)) == false) {
+		t.Fatalf("FirstUpdateTs %s, want %s", (*gotPtr).FirstUpdateTs, (*wantPtr).FirstUpdateTs)
+	}
+
+	for n := uint64(0); n < (*wantPtr).TotalSteps; n++ {
+		if (step_time(gotPtr, n).Equal(step_time(wantPtr, n)) == false) {
+			t.Errorf("step %d starts at %s, want %s", n, step_time(gotPtr, n), step_time(wantPtr, n))
+		}
+	}
+
+}
+
+func TestExportRoundTrip(t *testing.T) {
+
+	// each update is at the start of its step and one step is unknown
+	// the steps do not shift so the first counter has no rate in the export and in the Rrd
+	// a data source without a name is exported as ds and the index and imported at the index
+
+	var formats = []struct {
+		name			string
+		export			func(io.Writer, *Rrd) (error)
+		import_records		func(io.Reader, *Rrd) (error)
+	}{
+		{"CSV", ExportCSV, ImportCSV},
+		{"NDJSON", ExportNDJSON, ImportNDJSON},
+	}
+
+	var base = time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	for _, data_type := range []uint8{Gauge, Counter} {
+
+		for _, names := range [][]DataSource{nil, {{Name: "in"}, {Name: "out"}}} {
+
+			var r = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: data_type, DataSources: names}
+
+			for n := 0; n < 8; n++ {
+
+				if (n == 5) {
+					continue
+				}
+
+				var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute), []float64{float64(n * n) * 60, float64(n) * 30}, &r)
+				if (err != nil) {
+					t.Fatal(err)
+				}
+
+			}
+
+			for _, format := range formats {
+
+				var label = "named"
+				if (names == nil) {
+					label = "unnamed"
+				}
+
+				t.Run(format.name + " " + data_type_string(data_type) + " " + label, func(t *testing.T) {
+
+					var imported = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: data_type}
+					export_round_trip(t, &r, format.export, format.import_records, &imported)
+
+					check_same_steps(t, &r, &imported)
+					check_export_timestamps(t, &r, &imported)
+
+					var got, want = imported.Names(), r.Names()
+					if (len(got) != len(want) || got[0] != want[0] || got[1] != want[1]) {
+						t.Fatalf("the names are %q, want %q", got, want)
+					}
+
+					// a Rrd with the data sources of the export does not add data points
+					var existing = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: data_type, DataSources: names}
+					if (names == nil) {
+						existing.DataSources = []DataSource{{}, {}}
+					}
+
+					export_round_trip(t, &r, format.export, format.import_records, &existing)
+
+					check_same_steps(t, &r, &existing)
+
+				})
+
+			}
+
+		}
+
+	}
+
+}
@@ -0,0 +1,198 @@
+package rrd
+
+import (
+	"errors"
+	"time"
+)
+
+type FetchOptions struct {
+	// the data sources to return by name, all data sources when empty
+	Names			[]string
+	// return the stored values of a Counter, Derive or Absolute Rrd instead of the rates
+	Values			bool
+	// RrdSet.Fetch uses the Archive with the smallest Interval of at least Resolution, RrdSet.Best when 0
+	Resolution		time.Duration
+}
+
+type FetchResult struct {
+	// the step that contains start and the step that contains end
+	Start			time.Time
+	End			time.Time
+	// the Interval of the Rrd the steps are from
+	Resolution		time.Duration
+	// true when Values are rates per second
+	Rates			bool
+	// the name of each data source in Values
+	Names			[]string
+	// the start of each step
+	Timestamps		[]time.Time
+	// Values[step][data source], nil is unknown
+	Values			[][]*float64
+}
+
+func (rrdPtr *Rrd) Fetch(start time.Time, end time.Time, opts FetchOptions) (*FetchResult, error) {
+
+	// return the steps of the Rrd from the step that contains start to the step that contains end
+	// steps before FirstUpdateTs or after LastUpdate are not returned
+
+	var indexes, names, err = fetch_indexes(rrdPtr, opts.Names)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var rates = (*rrdPtr).HasRates() == true && opts.Values == false
+
+	return fetch(rrdPtr, start, end, indexes, names, rates), nil
+
+}
+
+func (setPtr *RrdSet) Fetch(start time.Time, end time.Time, opts FetchOptions) (*FetchResult, error) {
+
+	// Fetch from the Archive of the RrdSet for the time range and opts.Resolution
+	// the Primary Rrd is used when no Archive has data
+	// Archives store the rate of a Counter, Derive or Absolute RrdSet, opts.Values is used only with the Primary Rrd
+
+	// the data sources are defined by the Primary Rrd
+	var indexes, names, err = fetch_indexes(&(*setPtr).Primary, opts.Names)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var rrdPtr *Rrd
+
+	if (opts.Resolution > 0) {
+
+		for l := range (*setPtr).Archives {
+
+			var arc = (*setPtr).Archives[l]
+
+			if (arc.Rrd.FirstUpdateTs == nil || arc.Rrd.Interval < opts.Resolution) {
+				continue
+			}
+
+			if (rrdPtr == nil || arc.Rrd.Interval < (*rrdPtr).Interval) {
+				rrdPtr = &arc.Rrd
+			}
+
+		}
+
+	}
+
+	if (rrdPtr == nil) {
+		rrdPtr = (*setPtr).Best(start, end)
+	}
+
+	if (rrdPtr == nil) {
+		var rates = (*setPtr).Primary.HasRates() == true && opts.Values == false
+		return fetch(&(*setPtr).Primary, start, end, indexes, names, rates), nil
+	}
+
+	var result = fetch(rrdPtr, start, end, indexes, names, false)
+	result.Rates = is_rate_type((*setPtr).DataType)
+
+	return result, nil
+
+}
+
+func fetch_indexes(rrdPtr *Rrd, names []string) ([]int, []string, error) {
+
+	// return the index and name of each data source to fetch
+
+	if (len(names) == 0) {
+
+		names = (*rrdPtr).Names()
+		var indexes = make([]int, len(names))
+
+		for e := range indexes {
+			indexes[e] = e
+		}
+
+		return indexes, names, nil
+
+	}
+
+	var indexes = make([]int, len(names))
+
+	for n := range names {
+
+		var e, found = (*rrdPtr).Index(names[n])
+		if (found == false) {
+			return nil, nil, errors.New("the Rrd has no data source named " + names[n])
+		}
+
+		indexes[n] = e
+
+	}
+
+	return indexes, names, nil
+
+}
+
+func fetch(rrdPtr *Rrd, start time.Time, end time.Time, indexes []int, names []string, rates bool) (*FetchResult) {
+
+	// return the steps from the step that contains start to the step that contains end
+
+	var result FetchResult
+	result.Resolution = (*rrdPtr).Interval
+	result.Rates = rates
+	result.Names = names
+
+	if ((*rrdPtr).FirstUpdateTs == nil || end.Before(start)) {
+		return &result
+	}
+
+	var first = step_at(rrdPtr, start)
+	if (first < 0) {
+		first = 0
+	}
+
+	var last = step_at(rrdPtr, end)
+	var last_update = step_at(rrdPtr, (*rrdPtr).LastUpdate)
+	if (last > last_update) {
+		last = last_update
+	}
+	if (last >= int64((*rrdPtr).TotalSteps)) {
+		last = int64((*rrdPtr).TotalSteps) - 1
+	}
+
+	if (last < first) {
+		return &result
+	}
+
+	result.Start = (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(first))
+	result.End = (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(last))
+	result.Timestamps = make([]time.Time, 0, last - first + 1)
+	result.Values = make([][]*float64, 0, last - first + 1)
+
+	for n := first; n <= last; n++ {
+
+		var step = make([]*float64, len(indexes))
+		// one allocation for the values of the step
+		var values = make([]float64, len(indexes))
+
+		for i := range indexes {
+
+			var v float64
+			var known bool
+
+			if (rates == true) {
+				v, known = (*rrdPtr).Rate(uint64(n), indexes[i])
+			} else {
+				v, known = (*rrdPtr).Value(uint64(n), indexes[i])
+			}
+
+			if (known == true) {
+				values[i] = v
+				step[i] = &values[i]
+			}
+
+		}
+
+		result.Timestamps = append(result.Timestamps, (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(n)))
+		result.Values = append(result.Values, step)
+
+	}
+
+	return &result
+
+}
@@ -0,0 +1,796 @@
+package rrd
+
+import (
+	"os"
+	"fmt"
+	"math"
+	"time"
+	"slices"
+	"errors"
+	"encoding/json"
+	"encoding/binary"
+)
+
+// the file format
+//
+// header, file_header_size bytes, little endian
+// 	0	magic			[8]byte "RRDGOFMT"
+// 	8	version			uint32
+// 	12	DataType		uint8
+// 	13	Consolidation		uint8
+// 	14	flags			uint16, 1 when FirstUpdateTs is set, 2 when Weighting is rrd.WeightTime
+// 	16	Interval		int64 nanoseconds
+// 	24	TotalSteps		uint64
+// 	32	data points		uint64, the number of data points the file has space for
+// 	40	used data points	uint64, the number of data points with data
+// 	48	definitions size	uint64, the length of the definitions JSON
+// 	56	definitions space	uint64, the space for the definitions JSON
+// 	64	Head			uint64
+// 	72	FirstUpdateTs		int64 unix nanoseconds
+// 	80	LastUpdate		int64 unix nanoseconds
+// 	88	CurrentAvgCount		int64
+// 	96	MinimumDataPoints	uint64
+// 	104	generation		uint64, odd while a write is in progress and even after it
+// 	112	CurrentAvgTime		int64 nanoseconds
+// 	120	reserved
+// LastUpdateDataPoint, a float64 for each data point, NaN is nil
+// definitions JSON, DataSources, CounterPolicy, Alignment, TimeZone, GapPolicy and ClockPolicy
+// values, a float64 for each slot of each data point, FD[data point][slot], NaN is unknown
+// rates, the same as values, only with Counter, Derive and Absolute
+
+const (
+	FileVersion = 1
+)
+
+const (
+	file_magic = "RRDGOFMT"
+	file_header_size = 128
+	// the smallest space for the definitions JSON
+	file_definitions_space = 4096
+	file_flag_first_update = 1
+	file_flag_weight_time = 2
+)
+
+var (
+	// returned when a file is not a valid Rrd file or does not match the Rrd it is opened with
+	ErrInvalidFile = errors.New("invalid rrd file")
+	// returned when the Rrd has more data points than the file has space for
+	ErrFileFull = errors.New("rrd file has no space for the data points")
+	// returned by MappedFile.Read when the file is written for longer than MappedFile.ReadTimeout
+	ErrFileBusy = errors.New("rrd file is being written")
+)
+
+// a File is a Rrd stored in a file that is preallocated for TotalSteps
+// only the slots that change are written
+type File struct {
+	// the Rrd of the file, the Storage is rrd.Flat
+	Rrd			Rrd
+	Path			string
+	// the number of data points the file has space for
+	DataPointsSpace		uint64
+	file			*os.File
+	layout			file_layout
+	definitions_space	uint64
+	definitions		[]byte
+	generation		uint64
+	dirty_slots		map[uint64]bool
+}
+
+// the Interval, TotalSteps and DataType of a file, they do not change after CreateFile
+type file_layout struct {
+	interval		time.Duration
+	total_steps		uint64
+	data_type		uint8
+}
+
+func layout_of(rrdPtr *Rrd) (file_layout) {
+
+	return file_layout{interval: (*rrdPtr).Interval, total_steps: (*rrdPtr).TotalSteps, data_type: (*rrdPtr).DataType}
+
+}
+
+func (l file_layout) check(rrdPtr *Rrd) (error) {
+
+	// the Rrd must have the layout of the file, a Rrd with another layout is stored with CreateFile
+
+	if ((*rrdPtr).Interval != l.interval) {
+		return fmt.Errorf("%w, the Rrd Interval %s is not the file Interval %s", ErrInvalidFile, (*rrdPtr).Interval.String(), l.interval.String())
+	} else if ((*rrdPtr).TotalSteps != l.total_steps) {
+		return fmt.Errorf("%w, the Rrd TotalSteps %d is not the file TotalSteps %d", ErrInvalidFile, (*rrdPtr).TotalSteps, l.total_steps)
+	} else if ((*rrdPtr).DataType != l.data_type) {
+		return fmt.Errorf("%w, the Rrd DataType %s is not the file DataType %s", ErrInvalidFile, data_type_string((*rrdPtr).DataType), data_type_string(l.data_type))
+	}
+
+	return nil
+
+}
+
+// the data point definitions stored as JSON in the file
+type file_definitions struct {
+	CounterPolicy		CounterPolicy
+	DataSources		[]DataSource
+	Alignment		uint8		`json:",omitempty"`
+	TimeZone		string		`json:",omitempty"`
+	GapPolicy		uint8		`json:",omitempty"`
+	ClockPolicy		*ClockPolicy	`json:",omitempty"`
+}
+
+func definitions_of(rrdPtr *Rrd) (file_definitions) {
+
+	var d = file_definitions{CounterPolicy: (*rrdPtr).CounterPolicy, DataSources: slices.Clone((*rrdPtr).DataSources), Alignment: (*rrdPtr).Alignment, TimeZone: (*rrdPtr).TimeZone, GapPolicy: (*rrdPtr).GapPolicy}
+
+	if ((*rrdPtr).ClockPolicy != (ClockPolicy{})) {
+		// the ClockPolicy is not written when it is not set
+		var clock_policy = (*rrdPtr).ClockPolicy
+		d.ClockPolicy = &clock_policy
+	}
+
+	return d
+
+}
+
+func (d file_definitions) apply(rrdPtr *Rrd) {
+
+	// set the definitions of the Rrd, the DataSources are not shared
+
+	(*rrdPtr).CounterPolicy = d.CounterPolicy
+	(*rrdPtr).DataSources = slices.Clone(d.DataSources)
+	(*rrdPtr).Alignment = d.Alignment
+	(*rrdPtr).TimeZone = d.TimeZone
+	(*rrdPtr).GapPolicy = d.GapPolicy
+	(*rrdPtr).ClockPolicy = ClockPolicy{}
+	if (d.ClockPolicy != nil) {
+		(*rrdPtr).ClockPolicy = (*d.ClockPolicy)
+	}
+
+}
+
+func CreateFile(path string, rrdPtr *Rrd) (*File, error) {
+
+	// create a file for the configuration and data of the Rrd
+	// the file has space for the largest of Rrd.MinimumDataPoints, len(Rrd.DataSources) and the data points with data
+	// an existing file is replaced
+
+	if ((*rrdPtr).Interval <= 0 || (*rrdPtr).TotalSteps == 0) {
+		return nil, fmt.Errorf("%w, Interval and TotalSteps must be more than 0", ErrInvalidFile)
+	}
+
+	var data_points = (*rrdPtr).MinimumDataPoints
+	if (uint64(len((*rrdPtr).DataSources)) > data_points) {
+		data_points = uint64(len((*rrdPtr).DataSources))
+	}
+	if (uint64((*rrdPtr).DataPoints()) > data_points) {
+		data_points = uint64((*rrdPtr).DataPoints())
+	}
+
+	if (data_points == 0) {
+		return nil, fmt.Errorf("%w, the Rrd must have MinimumDataPoints or DataSources", ErrInvalidFile)
+	}
+
+	var f File
+	f.Path = path
+	f.DataPointsSpace = data_points
+	f.layout = layout_of(rrdPtr)
+	f.Rrd = (*rrdPtr)
+	f.Rrd.DataSources = slices.Clone((*rrdPtr).DataSources)
+
+	if (f.Rrd.Storage == Flat) {
+
+		// the File does not share the values of rrdPtr
+		f.Rrd.FD = clone_series((*rrdPtr).FD)
+		f.Rrd.FR = clone_series((*rrdPtr).FR)
+
+	} else {
+		ToFlat(&f.Rrd)
+	}
+
+	var definitions, err = json_definitions(&f.Rrd)
+	if (err != nil) {
+		return nil, err
+	}
+
+	f.definitions_space = file_definitions_space
+	for (uint64(len(definitions)) * 2 > f.definitions_space) {
+		f.definitions_space *= 2
+	}
+
+	f.file, err = os.Create(path)
+	if (err != nil) {
+		return nil, err
+	}
+
+	err = f.file.Truncate(file_size(f.Rrd.DataType, f.Rrd.TotalSteps, f.DataPointsSpace, f.definitions_space))
+	if (err != nil) {
+		f.file.Close()
+		return nil, err
+	}
+
+	// write every slot, unknown values are NaN
+	f.dirty_slots = make(map[uint64]bool)
+	for slot := uint64(0); slot < f.Rrd.TotalSteps; slot++ {
+		f.dirty_slots[slot] = true
+	}
+
+	f.Rrd.dirty = f.mark
+	f.Rrd.stored = true
+
+	err = f.Write()
+	if (err != nil) {
+		f.file.Close()
+		return nil, err
+	}
+
+	return &f, nil
+
+}
+
+func OpenFile(path string, rrdPtr *Rrd) (*File, error) {
+
+	// open a file created with CreateFile
+	// when rrdPtr is not nil the Interval, TotalSteps and DataType of the file must be the same as rrdPtr
+
+	var file, err = os.OpenFile(path, os.O_RDWR, 0)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var f File
+	f.Path = path
+	f.file = file
+
+	err = f.read(rrdPtr)
+	if (err != nil) {
+		file.Close()
+		return nil, err
+	}
+
+	f.dirty_slots = make(map[uint64]bool)
+	f.Rrd.dirty = f.mark
+	f.Rrd.stored = true
+
+	return &f, nil
+
+}
+
+func (fPtr *File) read(rrdPtr *Rrd) (error) {
+
+	// read and validate the header, then read the data
+
+	var header = make([]byte, file_header_size)
+
+	var _, err = (*fPtr).file.ReadAt(header, 0)
+	if (err != nil) {
+		return fmt.Errorf("%w, %w", ErrInvalidFile, err)
+	}
+
+	var stat, stat_err = (*fPtr).file.Stat()
+	if (stat_err != nil) {
+		return stat_err
+	}
+
+	var r, h, header_err = parse_file_header(header, stat.Size(), rrdPtr)
+	if (header_err != nil) {
+		return header_err
+	}
+
+	// LastUpdateDataPoint and the definitions
+	var b = make([]byte, h.data_points * 8 + h.definitions_size)
+	_, err = (*fPtr).file.ReadAt(b, file_header_size)
+	if (err != nil) {
+		return err
+	}
+
+	err = parse_file_definitions(b, h, &r)
+	if (err != nil) {
+		return err
+	}
+
+	(*fPtr).definitions = b[h.data_points * 8:]
+	(*fPtr).definitions_space = h.definitions_space
+	(*fPtr).DataPointsSpace = h.data_points
+	(*fPtr).generation = h.generation
+	(*fPtr).layout = layout_of(&r)
+
+	if (r.FirstUpdateTs != nil) {
+
+		// the data points with data
+		var region = make([]byte, r.TotalSteps * 8)
+
+		r.FD = make([]Series, h.used_data_points)
+		for ds := range r.FD {
+
+			_, err = (*fPtr).file.ReadAt(region, file_values_offset(r.TotalSteps, h.data_points, h.definitions_space, uint64(ds)))
+			if (err != nil) {
+				return err
+			}
+
+			r.FD[ds] = read_floats(region)
+
+		}
+
+		if (is_rate_type(r.DataType) == true) {
+
+			r.FR = make([]Series, h.used_data_points)
+			for ds := range r.FR {
+
+				_, err = (*fPtr).file.ReadAt(region, file_rates_offset(r.TotalSteps, h.data_points, h.definitions_space, uint64(ds)))
+				if (err != nil) {
+					return err
+				}
+
+				r.FR[ds] = read_floats(region)
+
+			}
+
+		}
+
+	}
+
+	(*fPtr).Rrd = r
+
+	return nil
+
+}
+
+// the fields of the file header that are not stored in the Rrd
+type file_header struct {
+	flags			uint16
+	first_update_ts		int64
+	data_points		uint64
+	used_data_points	uint64
+	definitions_size	uint64
+	definitions_space	uint64
+	generation		uint64
+}
+
+func parse_file_header(header []byte, size int64, rrdPtr *Rrd) (Rrd, file_header, error) {
+
+	// return the Rrd configuration and file_header of a header with the file size
+	// when rrdPtr is not nil the Interval, TotalSteps and DataType of the file must be the same as rrdPtr
+
+	var r Rrd
+	var h file_header
+
+	if (len(header) < file_header_size || string(header[0:8]) != file_magic) {
+		return r, h, fmt.Errorf("%w, the file does not start with %s", ErrInvalidFile, file_magic)
+	}
+
+	var version = binary.LittleEndian.Uint32(header[8:])
+	if (version != FileVersion) {
+		return r, h, fmt.Errorf("%w, version %d is not supported", ErrInvalidFile, version)
+	}
+
+	r.Storage = Flat
+	r.DataType = header[12]
+	r.Consolidation = header[13]
+	h.flags = binary.LittleEndian.Uint16(header[14:])
+	r.Interval = time.Duration(binary.LittleEndian.Uint64(header[16:]))
+	r.TotalSteps = binary.LittleEndian.Uint64(header[24:])
+	h.data_points = binary.LittleEndian.Uint64(header[32:])
+	h.used_data_points = binary.LittleEndian.Uint64(header[40:])
+	h.definitions_size = binary.LittleEndian.Uint64(header[48:])
+	h.definitions_space = binary.LittleEndian.Uint64(header[56:])
+	r.Head = binary.LittleEndian.Uint64(header[64:])
+	h.first_update_ts = int64(binary.LittleEndian.Uint64(header[72:]))
+	r.LastUpdate = time.Unix(0, int64(binary.LittleEndian.Uint64(header[80:])))
+	r.CurrentAvgCount = int64(binary.LittleEndian.Uint64(header[88:]))
+	r.MinimumDataPoints = binary.LittleEndian.Uint64(header[96:])
+	h.generation = binary.LittleEndian.Uint64(header[104:])
+	r.CurrentAvgTime = time.Duration(binary.LittleEndian.Uint64(header[112:]))
+
+	if (h.flags & file_flag_weight_time != 0) {
+		r.Weighting = WeightTime
+	}
+
+	if (r.DataType > Absolute) {
+		return r, h, fmt.Errorf("%w, DataType %d is not supported", ErrInvalidFile, r.DataType)
+	} else if (r.Interval <= 0) {
+		return r, h, fmt.Errorf("%w, Interval %s is not valid", ErrInvalidFile, r.Interval.String())
+	} else if (r.TotalSteps == 0 || r.Head >= r.TotalSteps) {
+		return r, h, fmt.Errorf("%w, TotalSteps %d with Head %d is not valid", ErrInvalidFile, r.TotalSteps, r.Head)
+	} else if (h.data_points == 0 || h.used_data_points > h.data_points || h.definitions_size > h.definitions_space) {
+		return r, h, fmt.Errorf("%w, the data point space is not valid", ErrInvalidFile)
+	}
+
+	if (rrdPtr != nil) {
+
+		if ((*rrdPtr).Interval != r.Interval) {
+			return r, h, fmt.Errorf("%w, the file Interval is %s", ErrInvalidFile, r.Interval.String())
+		} else if ((*rrdPtr).TotalSteps != r.TotalSteps) {
+			return r, h, fmt.Errorf("%w, the file TotalSteps is %d", ErrInvalidFile, r.TotalSteps)
+		} else if ((*rrdPtr).DataType != r.DataType) {
+			return r, h, fmt.Errorf("%w, the file DataType is %s", ErrInvalidFile, data_type_string(r.DataType))
+		}
+
+		r.Debug = (*rrdPtr).Debug
+		r.Clock = (*rrdPtr).Clock
+		r.OnClockJump = (*rrdPtr).OnClockJump
+
+	}
+
+	// each count is checked against the file size before it is multiplied, a larger count is not a valid file
+	if (size < file_header_size) {
+		return r, h, fmt.Errorf("%w, the file size %d is not valid", ErrInvalidFile, size)
+	}
+
+	var space = uint64(size) - file_header_size
+
+	var regions = h.data_points
+	if (is_rate_type(r.DataType) == true) {
+		regions *= 2
+	}
+
+	if (h.data_points > space / 8 || h.definitions_space > space - h.data_points * 8) {
+		return r, h, fmt.Errorf("%w, %d data points and %d bytes of definitions space are larger than the file", ErrInvalidFile, h.data_points, h.definitions_space)
+	} else if (r.TotalSteps > (space - h.data_points * 8 - h.definitions_space) / 8 / regions) {
+		return r, h, fmt.Errorf("%w, TotalSteps %d is larger than the file", ErrInvalidFile, r.TotalSteps)
+	}
+
+	if (size != file_size(r.DataType, r.TotalSteps, h.data_points, h.definitions_space)) {
+		return r, h, fmt.Errorf("%w, the file size %d is not valid", ErrInvalidFile, size)
+	}
+
+	if (h.flags & file_flag_first_update != 0) {
+		var ts = time.Unix(0, h.first_update_ts)
+		r.FirstUpdateTs = &ts
+	}
+
+	return r, h, nil
+
+}
+
+func parse_file_definitions(b []byte, h file_header, rrdPtr *Rrd) (error) {
+
+	// set LastUpdateDataPoint and the definitions of the Rrd from the bytes after the header
+
+	if ((*rrdPtr).FirstUpdateTs != nil) {
+		(*rrdPtr).LastUpdateDataPoint = parse_last_update(b[:h.data_points * 8], h.used_data_points)
+	}
+
+	var definitions file_definitions
+	var err = json.Unmarshal(b[h.data_points * 8:h.data_points * 8 + h.definitions_size], &definitions)
+	if (err != nil) {
+		return fmt.Errorf("%w, %w", ErrInvalidFile, err)
+	}
+
+	definitions.apply(rrdPtr)
+
+	return nil
+
+}
+
+func (fPtr *File) mark(slot uint64) {
+
+	// the dirty hook of the Rrd
+
+	(*fPtr).dirty_slots[slot] = true
+
+}
+
+func (fPtr *File) Update(updateDataPoint []*float64) (error) {
+
+	// rrd.UpdateErr then Write
+
+	return (*fPtr).UpdateAt(rrd_now(&(*fPtr).Rrd), updateDataPoint)
+
+}
+
+func (fPtr *File) UpdateAt(updateTimeStamp time.Time, updateDataPoint []*float64) (error) {
+
+	// rrd.UpdateAt then Write
+
+	if (uint64(len(updateDataPoint)) > (*fPtr).DataPointsSpace) {
+		return fmt.Errorf("%w, the update has %d values and the file has space for %d", ErrFileFull, len(updateDataPoint), (*fPtr).DataPointsSpace)
+	}
+
+	var err = UpdateAt(updateTimeStamp, updateDataPoint, &(*fPtr).Rrd)
+	if (err != nil) {
+		return err
+	}
+
+	return (*fPtr).Write()
+
+}
+
+func (fPtr *File) Write() (error) {
+
+	// write the slots that changed since the last Write and the header
+	// call Write after changing File.Rrd without File.Update, with rrd.UpdateMap or rrd.RecalculateRate for example
+
+	var r = &(*fPtr).Rrd
+
+	var layout_err = (*fPtr).layout.check(r)
+	if (layout_err != nil) {
+		return layout_err
+	}
+
+	if (uint64((*r).DataPoints()) > (*fPtr).DataPointsSpace) {
+		return fmt.Errorf("%w, the Rrd has %d data points and the file has space for %d", ErrFileFull, (*r).DataPoints(), (*fPtr).DataPointsSpace)
+	}
+
+	var definitions, err = json_definitions(r)
+	if (err != nil) {
+		return err
+	}
+
+	if (uint64(len(definitions)) > (*fPtr).definitions_space) {
+		return fmt.Errorf("%w, the DataSources are larger than the %d bytes of definitions space", ErrFileFull, (*fPtr).definitions_space)
+	}
+
+	var slots = make([]uint64, 0, len((*fPtr).dirty_slots))
+	for slot := range (*fPtr).dirty_slots {
+		slots = append(slots, slot)
+	}
+	slices.Sort(slots)
+
+	// the generation is odd while the slots and the header are written, a MappedFile reader retries until it is even
+	(*fPtr).generation |= 1
+
+	err = (*fPtr).write_generation()
+	if (err != nil) {
+		return err
+	}
+
+	for ds := uint64(0); ds < (*fPtr).DataPointsSpace; ds++ {
+
+		err = write_slots((*fPtr).file, (*r).FD, ds, slots, file_values_offset((*r).TotalSteps, (*fPtr).DataPointsSpace, (*fPtr).definitions_space, ds))
+		if (err != nil) {
+			return err
+		}
+
+		if (is_rate_type((*r).DataType) == false) {
+			continue
+		}
+
+		err = write_slots((*fPtr).file, (*r).FR, ds, slots, file_rates_offset((*r).TotalSteps, (*fPtr).DataPointsSpace, (*fPtr).definitions_space, ds))
+		if (err != nil) {
+			return err
+		}
+
+	}
+
+	if (slices.Equal(definitions, (*fPtr).definitions) == false) {
+
+		_, err = (*fPtr).file.WriteAt(definitions, int64(file_header_size + (*fPtr).DataPointsSpace * 8))
+		if (err != nil) {
+			return err
+		}
+
+		(*fPtr).definitions = definitions
+
+	}
+
+	err = (*fPtr).write_header()
+	if (err != nil) {
+		return err
+	}
+
+	// the even generation is written last
+	(*fPtr).generation += 1
+
+	err = (*fPtr).write_generation()
+	if (err != nil) {
+		return err
+	}
+
+	clear((*fPtr).dirty_slots)
+
+	return nil
+
+}
+
+func (fPtr *File) write_generation() (error) {
+
+	// write the generation of the header
+
+	var b = make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, (*fPtr).generation)
+
+	var _, err = (*fPtr).file.WriteAt(b, 104)
+
+	return err
+
+}
+
+func (fPtr *File) write_header() (error) {
+
+	// write the header and LastUpdateDataPoint
+
+	var b = make([]byte, file_header_size + (*fPtr).DataPointsSpace * 8)
+
+	put_file_header(b, &(*fPtr).Rrd, (*fPtr).DataPointsSpace, uint64(len((*fPtr).definitions)), (*fPtr).definitions_space, (*fPtr).generation)
+
+	var _, err = (*fPtr).file.WriteAt(b, 0)
+
+	return err
+
+}
+
+func parse_last_update(b []byte, used_data_points uint64) ([]*float64) {
+
+	// return the LastUpdateDataPoint of the used data points, NaN is nil
+
+	var last = read_floats(b)
+	var values = make([]*float64, used_data_points)
+
+	for e := range values {
+		if (math.IsNaN(last[e]) == false) {
+			values[e] = &last[e]
+		}
+	}
+
+	return values
+
+}
+
+func put_file_header(b []byte, rrdPtr *Rrd, data_points uint64, definitions_size uint64, definitions_space uint64, generation uint64) {
+
+	// put the header and LastUpdateDataPoint of the Rrd in b
+
+	copy(b[0:8], file_magic)
+	binary.LittleEndian.PutUint32(b[8:], FileVersion)
+	b[12] = (*rrdPtr).DataType
+	b[13] = (*rrdPtr).Consolidation
+
+	var flags uint16
+	var first_update_ts int64
+	if ((*rrdPtr).FirstUpdateTs != nil) {
+		flags |= file_flag_first_update
+		first_update_ts = (*(*rrdPtr).FirstUpdateTs).UnixNano()
+	}
+	if ((*rrdPtr).Weighting == WeightTime) {
+		flags |= file_flag_weight_time
+	}
+
+	binary.LittleEndian.PutUint16(b[14:], flags)
+	binary.LittleEndian.PutUint64(b[16:], uint64((*rrdPtr).Interval))
+	binary.LittleEndian.PutUint64(b[24:], (*rrdPtr).TotalSteps)
+	binary.LittleEndian.PutUint64(b[32:], data_points)
+	binary.LittleEndian.PutUint64(b[40:], uint64((*rrdPtr).DataPoints()))
+	binary.LittleEndian.PutUint64(b[48:], definitions_size)
+	binary.LittleEndian.PutUint64(b[56:], definitions_space)
+	binary.LittleEndian.PutUint64(b[64:], (*rrdPtr).Head)
+	binary.LittleEndian.PutUint64(b[72:], uint64(first_update_ts))
+	binary.LittleEndian.PutUint64(b[80:], uint64((*rrdPtr).LastUpdate.UnixNano()))
+	binary.LittleEndian.PutUint64(b[88:], uint64((*rrdPtr).CurrentAvgCount))
+	binary.LittleEndian.PutUint64(b[96:], (*rrdPtr).MinimumDataPoints)
+	binary.LittleEndian.PutUint64(b[104:], generation)
+	binary.LittleEndian.PutUint64(b[112:], uint64((*rrdPtr).CurrentAvgTime))
+
+	for e := uint64(0); e < data_points; e++ {
+
+		var v = math.NaN()
+		if (e < uint64(len((*rrdPtr).LastUpdateDataPoint)) && (*rrdPtr).LastUpdateDataPoint[e] != nil) {
+			v = (*(*rrdPtr).LastUpdateDataPoint[e])
+		}
+
+		binary.LittleEndian.PutUint64(b[file_header_size + e * 8:], math.Float64bits(v))
+
+	}
+
+}
+
+func (fPtr *File) Sync() (error) {
+
+	// commit the file to storage
+
+	return (*fPtr).file.Sync()
+
+}
+
+func (fPtr *File) Close() (error) {
+
+	// write the changes and close the file
+
+	var err = (*fPtr).Write()
+
+	var close_err = (*fPtr).file.Close()
+
+	(*fPtr).Rrd.dirty = nil
+	(*fPtr).Rrd.stored = false
+
+	return errors.Join(err, close_err)
+
+}
+
+func write_slots(file *os.File, series []Series, ds uint64, slots []uint64, offset int64) (error) {
+
+	// write the slots of data point ds, each run of consecutive slots is one write
+	// data points without a Series are written as unknown
+
+	var b []byte
+
+	for l := 0; l < len(slots); {
+
+		var end = l + 1
+		for (end < len(slots) && slots[end] == slots[end - 1] + 1) {
+			end += 1
+		}
+
+		b = b[:0]
+
+		for _, slot := range slots[l:end] {
+
+			var v = math.NaN()
+			if (ds < uint64(len(series)) && slot < uint64(len(series[ds]))) {
+				v = series[ds][slot]
+			}
+
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+
+		}
+
+		var _, err = file.WriteAt(b, offset + int64(slots[l] * 8))
+		if (err != nil) {
+			return err
+		}
+
+		l = end
+
+	}
+
+	return nil
+
+}
+
+func json_definitions(rrdPtr *Rrd) ([]byte, error) {
+
+	// return the definitions JSON of the Rrd
+
+	return json.Marshal(definitions_of(rrdPtr))
+
+}
+
+func clone_series(series []Series) ([]Series) {
+
+	if (series == nil) {
+		return nil
+	}
+
+	var c = make([]Series, len(series))
+	for ds := range series {
+		c[ds] = slices.Clone(series[ds])
+	}
+
+	return c
+
+}
+
+func read_floats(b []byte) (Series) {
+
+	var s = make(Series, len(b) / 8)
+
+	for l := range s {
+		s[l] = math.Float64frombits(binary.LittleEndian.Uint64(b[l * 8:]))
+	}
+
+	return s
+
+}
+
+func file_values_offset(total_steps uint64, data_points uint64, definitions_space uint64, ds uint64) (int64) {
+
+	// the offset of the values of data point ds
+
+	return int64(file_header_size + data_points * 8 + definitions_space + ds * total_steps * 8)
+
+}
+
+func file_rates_offset(total_steps uint64, data_points uint64, definitions_space uint64, ds uint64) (int64) {
+
+	// the offset of the rates of data point ds
+
+	return file_values_offset(total_steps, data_points, definitions_space, data_points + ds)
+
+}
+
+func file_size(data_type uint8, total_steps uint64, data_points uint64, definitions_space uint64) (int64) {
+
+	var regions = data_points
+	if (is_rate_type(data_type) == true) {
+		regions *= 2
+	}
+
+	return file_values_offset(total_steps, data_points, definitions_space, regions)
+
+}
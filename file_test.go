@@ -0,0 +1,208 @@
+package rrd
+
+import (
+	"os"
+	"math"
+	"time"
+	"errors"
+	"slices"
+	"testing"
+	"path/filepath"
+	"encoding/binary"
+)
+
+func TestFileRoundTrip(t *testing.T) {
+
+	// a Rrd with Pointer storage is created, updated in the File and read with OpenFile
+
+	var path = filepath.Join(t.TempDir(), "test.rrd")
+	var base = time.Unix(1700000000, 0)
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 10, DataType: Counter, Storage: Pointer}
+	r.DataSources = []DataSource{{Name: "in"}, {Name: "out"}}
+
+	for n := 0; n < 4; n++ {
+
+		var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Second), []float64{float64(n * 100), float64(n * 10)}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	var f, err = CreateFile(path, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	// the steps wrap around TotalSteps
+	for n := 4; n < 25; n++ {
+
+		var in, out = float64(n * 100), float64(n * 10)
+		err = f.UpdateAt(base.Add(time.Duration(n) * time.Second), []*float64{&in, &out})
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	// an update between steps
+	var in = 2600.0
+	err = f.UpdateAt(base.Add(25500 * time.Millisecond), []*float64{&in, nil})
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	opened, err := OpenFile(path, nil)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+	defer opened.Close()
+
+	check_same_steps(t, &f.Rrd, &opened.Rrd)
+
+	if ((*opened.Rrd.FirstUpdateTs).Equal((*f.Rrd.FirstUpdateTs)) == false || opened.Rrd.CurrentAvgCount != f.Rrd.CurrentAvgCount || opened.Rrd.CurrentAvgTime != f.Rrd.CurrentAvgTime) {
+		t.Fatalf("FirstUpdateTs %s, CurrentAvgCount %d and CurrentAvgTime %s, want %s, %d and %s", opened.Rrd.FirstUpdateTs, opened.Rrd.CurrentAvgCount, opened.Rrd.CurrentAvgTime, f.Rrd.FirstUpdateTs, f.Rrd.CurrentAvgCount, f.Rrd.CurrentAvgTime)
+	}
+
+	var names = opened.Rrd.Names()
+	if (len(names) != 2 || names[0] != "in" || names[1] != "out") {
+		t.Fatalf("the names are %v", names)
+	}
+
+}
+
+func TestOpenFileLayout(t *testing.T) {
+
+	// a File opened with a Rrd of another layout and a File.Rrd with another layout are not valid
+
+	var path = filepath.Join(t.TempDir(), "test.rrd")
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 10, DataType: Gauge, MinimumDataPoints: 1}
+
+	var f, err = CreateFile(path, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	f.Rrd.TotalSteps = 20
+	err = f.Write()
+	if (errors.Is(err, ErrInvalidFile) == false) {
+		t.Fatalf("Write with another TotalSteps returned %v, want ErrInvalidFile", err)
+	}
+
+	f.Rrd.TotalSteps = 10
+	err = f.Close()
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	r.Interval = time.Minute
+	_, err = OpenFile(path, &r)
+	if (errors.Is(err, ErrInvalidFile) == false) {
+		t.Fatalf("OpenFile with another Interval returned %v, want ErrInvalidFile", err)
+	}
+
+}
+
+func TestOpenFileCraftedHeader(t *testing.T) {
+
+	// counts that overflow when they are multiplied and a truncated file are not valid files
+
+	var dir = t.TempDir()
+	var path = filepath.Join(dir, "test.rrd")
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 10, DataType: Gauge}
+
+	var err = UpdateFloatAt(time.Unix(1700000000, 0), []float64{1}, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	f, err := CreateFile(path, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	valid, err := os.ReadFile(path)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	var definitions_space = binary.LittleEndian.Uint64(valid[56:])
+
+	var tests = []struct {
+		name			string
+		data			func() ([]byte)
+	}{
+		{
+			// data_points * 8 and data_points * TotalSteps * 8 are 0
+			name: "data points of 1<<61",
+			data: func() ([]byte) {
+				var b = slices.Clone(valid[:file_header_size + definitions_space])
+				binary.LittleEndian.PutUint64(b[32:], 1 << 61)
+				return b
+			},
+		},
+		{
+			// TotalSteps * 8 is 0
+			name: "TotalSteps of 1<<61",
+			data: func() ([]byte) {
+				var b = slices.Clone(valid[:file_header_size + 8 + definitions_space])
+				binary.LittleEndian.PutUint64(b[24:], 1 << 61)
+				return b
+			},
+		},
+		{
+			name: "definitions space larger than the file",
+			data: func() ([]byte) {
+				var b = slices.Clone(valid)
+				binary.LittleEndian.PutUint64(b[56:], math.MaxUint64 - 7)
+				return b
+			},
+		},
+		{
+			name: "truncated values",
+			data: func() ([]byte) {
+				return valid[:len(valid) - 8]
+			},
+		},
+		{
+			name: "truncated header",
+			data: func() ([]byte) {
+				return valid[:file_header_size / 2]
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			var crafted = filepath.Join(dir, "crafted.rrd")
+
+			var err = os.WriteFile(crafted, test.data(), 0644)
+			if (err != nil) {
+				t.Fatal(err)
+			}
+
+			_, err = OpenFile(crafted, nil)
+			if (errors.Is(err, ErrInvalidFile) == false) {
+				t.Fatalf("OpenFile returned %v, want ErrInvalidFile", err)
+			}
+
+		})
+
+	}
+
+}
@@ -0,0 +1,72 @@
+package rrd
+
+import (
+	"testing"
+)
+
+func check_same_steps(t *testing.T, wantPtr *Rrd, gotPtr *Rrd) {
+
+	// every step of wantPtr has the same values and rates in the step of gotPtr that contains its start
+	// LastUpdate, the data points and LastUpdateDataPoint are the same, the steps of gotPtr may start before or end after wantPtr
+
+	t.Helper()
+
+	if ((*gotPtr).LastUpdate.Equal((*wantPtr).LastUpdate) == false) {
+		t.Fatalf("LastUpdate %s, want %s", (*gotPtr).LastUpdate, (*wantPtr).LastUpdate)
+	}
+
+	if ((*gotPtr).DataPoints() != (*wantPtr).DataPoints()) {
+		t.Fatalf("%d data points, want %d", (*gotPtr).DataPoints(), (*wantPtr).DataPoints())
+	}
+
+	if ((*wantPtr).FirstUpdateTs == nil) {
+		return
+	}
+
+	if ((*gotPtr).FirstUpdateTs == nil) {
+		t.Fatal("FirstUpdateTs is nil")
+	}
+
+	for n := uint64(0); n < (*wantPtr).TotalSteps; n++ {
+
+		var ts = step_time(wantPtr, n)
+
+		var step = step_at(gotPtr, ts)
+		if (step < 0 || uint64(step) >= (*gotPtr).TotalSteps) {
+			t.Fatalf("the step at %s is not in the steps", ts)
+		}
+
+		for ds := 0; ds < (*wantPtr).DataPoints(); ds++ {
+
+			var v, known = (*wantPtr).Value(n, ds)
+			var got, got_known = (*gotPtr).Value(uint64(step), ds)
+			if (known != got_known || (known == true && v != got)) {
+				t.Errorf("the value of data point %d at %s is %v known %t, want %v known %t", ds, ts, got, got_known, v, known)
+			}
+
+			v, known = (*wantPtr).Rate(n, ds)
+			got, got_known = (*gotPtr).Rate(uint64(step), ds)
+			if (known != got_known || (known == true && v != got)) {
+				t.Errorf("the rate of data point %d at %s is %v known %t, want %v known %t", ds, ts, got, got_known, v, known)
+			}
+
+		}
+
+	}
+
+	for ds := range (*wantPtr).LastUpdateDataPoint {
+
+		var v = (*wantPtr).LastUpdateDataPoint[ds]
+
+		var got *float64
+		if (ds < len((*gotPtr).LastUpdateDataPoint)) {
+			got = (*gotPtr).LastUpdateDataPoint[ds]
+		}
+
+		if ((v == nil) != (got == nil) || (v != nil && (*v) != (*got))) {
+			t.Errorf("LastUpdateDataPoint %d is %v, want %v", ds, got, v)
+		}
+
+	}
+
+}
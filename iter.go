@@ -0,0 +1,99 @@
+package rrd
+
+import (
+	"iter"
+	"math"
+	"time"
+)
+
+func (rrdPtr *Rrd) Steps() (iter.Seq2[time.Time, []float64]) {
+
+	// iterate the start of each of the TotalSteps steps from FirstUpdateTs with the value of each data point, NaN is unknown
+	// the slice is reused for each step, copy it to keep it
+
+	return steps(rrdPtr, (*rrdPtr).Value)
+
+}
+
+func (rrdPtr *Rrd) Rates() (iter.Seq2[time.Time, []float64]) {
+
+	// Steps with the rate per second of each data point of a Counter, Derive or Absolute Rrd
+
+	return steps(rrdPtr, (*rrdPtr).Rate)
+
+}
+
+func (rrdPtr *Rrd) Series(ds int) (iter.Seq2[time.Time, float64]) {
+
+	// iterate the start of each of the TotalSteps steps from FirstUpdateTs with the value of data point ds, NaN is unknown
+
+	return series(rrdPtr, ds, (*rrdPtr).Value)
+
+}
+
+func (rrdPtr *Rrd) RateSeries(ds int) (iter.Seq2[time.Time, float64]) {
+
+	// Series with the rate per second of data point ds of a Counter, Derive or Absolute Rrd
+
+	return series(rrdPtr, ds, (*rrdPtr).Rate)
+
+}
+
+func steps(rrdPtr *Rrd, get func(uint64, int) (float64, bool)) (iter.Seq2[time.Time, []float64]) {
+
+	return func(yield func(time.Time, []float64) (bool)) {
+
+		if ((*rrdPtr).FirstUpdateTs == nil) {
+			return
+		}
+
+		var values = make([]float64, (*rrdPtr).DataPoints())
+
+		for n := uint64(0); n < (*rrdPtr).TotalSteps; n++ {
+
+			for e := range values {
+				values[e], _ = get(n, e)
+			}
+
+			if (yield(step_time(rrdPtr, n), values) == false) {
+				return
+			}
+
+		}
+
+	}
+
+}
+
+func series(rrdPtr *Rrd, ds int, get func(uint64, int) (float64, bool)) (iter.Seq2[time.Time, float64]) {
+
+	return func(yield func(time.Time, float64) (bool)) {
+
+		if ((*rrdPtr).FirstUpdateTs == nil) {
+			return
+		}
+
+		for n := uint64(0); n < (*rrdPtr).TotalSteps; n++ {
+
+			var v, known = get(n, ds)
+			if (known == false) {
+				v = math.NaN()
+			}
+
+			if (yield(step_time(rrdPtr, n), v) == false) {
+				return
+			}
+
+		}
+
+	}
+
+}
+
+func step_time(rrdPtr *Rrd, step uint64) (time.Time) {
+
+	// return the start of step counted from FirstUpdateTs
+
+	return (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(step))
+
+}
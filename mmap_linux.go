@@ -0,0 +1,452 @@
+//go:build linux
+
+package rrd
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"slices"
+	"errors"
+	"unsafe"
+	"runtime"
+	"syscall"
+	"sync"
+	"sync/atomic"
+)
+
+// a MappedFile is a File created with CreateFile that is memory mapped
+// the values are read and written in the mapping so other processes can read the Rrd without copying the file
+// the generation in the header is odd while the Rrd is written, readers retry until they read the same even generation before and after
+// Read is zero-copy, the function of Read reads FD and FR in the mapping
+type MappedFile struct {
+	// the Rrd of a writable MappedFile, FD and FR are in the mapping
+	// only change it with MappedFile.Write
+	Rrd			Rrd
+	Path			string
+	Writable		bool
+	// the number of data points the file has space for
+	DataPointsSpace		uint64
+	// the longest time Read retries while the Rrd is written before it returns ErrFileBusy, 0 is a second
+	ReadTimeout		time.Duration
+	file			*os.File
+	layout			file_layout
+	data			[]byte
+	generation		*uint64
+	definitions_space	uint64
+	// the definitions of the last Read or Write, Read is called by any number of goroutines
+	definitions_lock	sync.Mutex
+	definitions		[]byte
+	definitions_parsed	file_definitions
+	// FD and FR of each data point the file has space for
+	values			[]Series
+	rates			[]Series
+}
+
+func MapFile(path string, rrdPtr *Rrd, writable bool) (*MappedFile, error) {
+
+	// map a file created with CreateFile
+	// one process writes with writable true, any number of processes read with writable false
+	// when rrdPtr is not nil the Interval, TotalSteps and DataType of the file must be the same as rrdPtr
+
+	var native uint16 = 1
+	if (*(*byte)(unsafe.Pointer(&native)) != 1) {
+		return nil, fmt.Errorf("%w, MapFile requires a little endian system", ErrInvalidFile)
+	}
+
+	var flag = os.O_RDONLY
+	var prot = syscall.PROT_READ
+	if (writable == true) {
+		flag = os.O_RDWR
+		prot |= syscall.PROT_WRITE
+	}
+
+	var file, err = os.OpenFile(path, flag, 0)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var stat, stat_err = file.Stat()
+	if (stat_err != nil) {
+		file.Close()
+		return nil, stat_err
+	}
+
+	if (stat.Size() < file_header_size) {
+		file.Close()
+		return nil, fmt.Errorf("%w, the file size %d is not valid", ErrInvalidFile, stat.Size())
+	}
+
+	var m MappedFile
+	m.Path = path
+	m.Writable = writable
+	m.file = file
+
+	m.data, err = syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), prot, syscall.MAP_SHARED)
+	if (err != nil) {
+		file.Close()
+		return nil, err
+	}
+
+	m.generation = (*uint64)(unsafe.Pointer(&m.data[104]))
+
+	if (writable == true && atomic.LoadUint64(m.generation) % 2 == 1) {
+		// a writer stopped during a write or another writer is writing, the values may be from different updates
+		m.Close()
+		return nil, fmt.Errorf("%w, the generation is odd, a writer is writing or stopped during a write, File.Write of OpenFile accepts the values in the file", ErrFileBusy)
+	}
+
+	// the layout does not change after CreateFile
+	var r, h, header_err = parse_file_header(m.data[:file_header_size], stat.Size(), rrdPtr)
+	if (header_err != nil) {
+		m.Close()
+		return nil, header_err
+	}
+
+	m.DataPointsSpace = h.data_points
+	m.definitions_space = h.definitions_space
+	m.layout = layout_of(&r)
+
+	m.values = make([]Series, h.data_points)
+	for ds := range m.values {
+		m.values[ds] = m.view(file_values_offset(r.TotalSteps, h.data_points, h.definitions_space, uint64(ds)), r.TotalSteps)
+	}
+
+	if (is_rate_type(r.DataType) == true) {
+		m.rates = make([]Series, h.data_points)
+		for ds := range m.rates {
+			m.rates[ds] = m.view(file_rates_offset(r.TotalSteps, h.data_points, h.definitions_space, uint64(ds)), r.TotalSteps)
+		}
+	}
+
+	if (writable == true) {
+
+		// the writer keeps the Rrd of the file
+		err = m.Read(func(rrdPtr *Rrd) {
+			m.Rrd = (*rrdPtr)
+			m.Rrd.DataSources = slices.Clone((*rrdPtr).DataSources)
+		})
+
+		if (err != nil) {
+			m.Close()
+			return nil, err
+		}
+
+		// FD and FR of the writer are in the mapping
+		if (m.Rrd.FD != nil) {
+			m.Rrd.FD = slices.Clone(m.values[:len(m.Rrd.FD)])
+		}
+		if (m.Rrd.FR != nil) {
+			m.Rrd.FR = slices.Clone(m.rates[:len(m.Rrd.FR)])
+		}
+
+		m.Rrd.stored = true
+
+		if (rrdPtr != nil) {
+			m.Rrd.Debug = (*rrdPtr).Debug
+			m.Rrd.Clock = (*rrdPtr).Clock
+			m.Rrd.OnClockJump = (*rrdPtr).OnClockJump
+		}
+
+	}
+
+	return &m, nil
+
+}
+
+func (mPtr *MappedFile) view(offset int64, length uint64) (Series) {
+
+	// return the float64 values at offset of the mapping
+
+	return unsafe.Slice((*float64)(unsafe.Pointer(&(*mPtr).data[offset])), length)
+
+}
+
+func (mPtr *MappedFile) Read(fn func(*Rrd)) (error) {
+
+	// call fn with the Rrd of the mapping, FD and FR are the values in the mapping and are not copied
+	// fn is called again when the Rrd was written while fn was running, results of fn are valid when Read returns nil
+	// the values fn reads while the Rrd is written may be from different updates, fn must not fail because of them
+	// the Rrd must not be changed or kept after fn returns
+	// Read can be called by any number of goroutines
+	// ErrFileBusy is returned when the Rrd is written for longer than ReadTimeout, like when a writer stopped during a write
+
+	var timeout = (*mPtr).ReadTimeout
+	if (timeout == 0) {
+		timeout = time.Second
+	}
+
+	var start time.Time
+	var header = make([]byte, file_header_size)
+
+	for attempt := 0; ; attempt++ {
+
+		if (attempt > 0) {
+
+			if (start.IsZero() == true) {
+				start = time.Now()
+			} else if (time.Since(start) > timeout) {
+				return fmt.Errorf("%w, the generation did not stay even for %s", ErrFileBusy, timeout)
+			}
+
+			runtime.Gosched()
+
+		}
+
+		var generation = atomic.LoadUint64((*mPtr).generation)
+
+		if (generation % 2 == 1) {
+			// the Rrd is being written
+			continue
+		}
+
+		atomic_copy(header, (*mPtr).data)
+
+		var r, h, err = parse_file_header(header, int64(len((*mPtr).data)), nil)
+
+		if (err == nil) {
+			err = (*mPtr).read_definitions(h, &r)
+		}
+
+		if (err != nil) {
+
+			read_barrier()
+			if (atomic.LoadUint64((*mPtr).generation) != generation) {
+				// the header was written while it was read
+				continue
+			}
+
+			return err
+
+		}
+
+		if (r.FirstUpdateTs != nil) {
+
+			r.FD = (*mPtr).values[:h.used_data_points:h.used_data_points]
+			if ((*mPtr).rates != nil) {
+				r.FR = (*mPtr).rates[:h.used_data_points:h.used_data_points]
+			}
+
+		}
+
+		fn(&r)
+
+		read_barrier()
+		if (atomic.LoadUint64((*mPtr).generation) == generation) {
+			return nil
+		}
+
+	}
+
+}
+
+// written by read_barrier
+var read_fence uint64
+
+func read_barrier() {
+
+	// order the reads of the mapping before the next load of the generation
+	// an atomic load only orders the reads after it, the store of an atomic add also orders the reads before it
+
+	atomic.AddUint64(&read_fence, 1)
+
+}
+
+func atomic_copy(dst []byte, src []byte) {
+
+	// copy len(dst) bytes of src with 8 byte atomic loads, src starts at a multiple of 8 of the mapping and len(dst) is a multiple of 8
+
+	for o := 0; o < len(dst); o += 8 {
+		*(*uint64)(unsafe.Pointer(&dst[o])) = atomic.LoadUint64((*uint64)(unsafe.Pointer(&src[o])))
+	}
+
+}
+
+func (mPtr *MappedFile) read_definitions(h file_header, rrdPtr *Rrd) (error) {
+
+	// set LastUpdateDataPoint and the definitions of the Rrd from the mapping
+	// the definitions are parsed again only when they change
+
+	// the copy is a multiple of 8 bytes within the definitions space
+	var size = h.data_points * 8 + (h.definitions_size + 7) / 8 * 8
+
+	var b = make([]byte, size)
+	atomic_copy(b, (*mPtr).data[file_header_size:])
+	b = b[:h.data_points * 8 + h.definitions_size]
+
+	var definitions = b[h.data_points * 8:]
+
+	(*mPtr).definitions_lock.Lock()
+	defer (*mPtr).definitions_lock.Unlock()
+
+	if (slices.Equal(definitions, (*mPtr).definitions) == true) {
+
+		if ((*rrdPtr).FirstUpdateTs != nil) {
+			(*rrdPtr).LastUpdateDataPoint = parse_last_update(b[:h.data_points * 8], h.used_data_points)
+		}
+
+		(*mPtr).definitions_parsed.apply(rrdPtr)
+
+		return nil
+
+	}
+
+	var err = parse_file_definitions(b, h, rrdPtr)
+	if (err != nil) {
+		return err
+	}
+
+	(*mPtr).definitions = slices.Clone(definitions)
+	(*mPtr).definitions_parsed = definitions_of(rrdPtr)
+
+	return nil
+
+}
+
+func (mPtr *MappedFile) Write(fn func(*Rrd) (error)) (error) {
+
+	// call fn with MappedFile.Rrd then write the header
+	// readers do not use the Rrd until Write returns
+
+	if ((*mPtr).Writable == false) {
+		return fmt.Errorf("%w, the MappedFile is not writable", ErrInvalidFile)
+	}
+
+	var r = &(*mPtr).Rrd
+
+	// odd, the Rrd is being written
+	atomic.AddUint64((*mPtr).generation, 1)
+	defer atomic.AddUint64((*mPtr).generation, 1)
+
+	var err = fn(r)
+
+	var layout_err = (*mPtr).layout.check(r)
+	if (layout_err != nil) {
+		return layout_err
+	}
+
+	if (uint64((*r).DataPoints()) > (*mPtr).DataPointsSpace) {
+
+		// the data points the file does not have space for are removed
+		err = fmt.Errorf("%w, the Rrd has %d data points and the file has space for %d", ErrFileFull, (*r).DataPoints(), (*mPtr).DataPointsSpace)
+
+		(*r).FD = (*r).FD[:(*mPtr).DataPointsSpace]
+		if ((*r).FR != nil) {
+			(*r).FR = (*r).FR[:(*mPtr).DataPointsSpace]
+		}
+		if (uint64((*r).MinimumDataPoints) > (*mPtr).DataPointsSpace) {
+			(*r).MinimumDataPoints = (*mPtr).DataPointsSpace
+		}
+
+	}
+
+	(*mPtr).bind()
+
+	var definitions, json_err = json_definitions(r)
+	if (json_err != nil) {
+		return json_err
+	}
+
+	if (uint64(len(definitions)) > (*mPtr).definitions_space) {
+		return fmt.Errorf("%w, the DataSources are larger than the %d bytes of definitions space", ErrFileFull, (*mPtr).definitions_space)
+	}
+
+	(*mPtr).definitions_lock.Lock()
+	defer (*mPtr).definitions_lock.Unlock()
+
+	if (slices.Equal(definitions, (*mPtr).definitions) == false) {
+
+		copy((*mPtr).data[file_header_size + (*mPtr).DataPointsSpace * 8:], definitions)
+
+		(*mPtr).definitions = definitions
+		(*mPtr).definitions_parsed = definitions_of(r)
+
+	}
+
+	var b = make([]byte, file_header_size + (*mPtr).DataPointsSpace * 8)
+	put_file_header(b, r, (*mPtr).DataPointsSpace, uint64(len(definitions)), (*mPtr).definitions_space, 0)
+
+	// the generation is not written
+	copy((*mPtr).data[:104], b[:104])
+	copy((*mPtr).data[112:len(b)], b[112:])
+
+	return err
+
+}
+
+func (mPtr *MappedFile) bind() {
+
+	// move FD and FR of the Rrd to the mapping when they were allocated by an update
+
+	var r = &(*mPtr).Rrd
+
+	for ds := range (*r).FD {
+
+		if (len((*r).FD[ds]) == 0 || &(*r).FD[ds][0] != &(*mPtr).values[ds][0]) {
+			copy((*mPtr).values[ds], (*r).FD[ds])
+			(*r).FD[ds] = (*mPtr).values[ds]
+		}
+
+	}
+
+	for ds := range (*r).FR {
+
+		if (len((*r).FR[ds]) == 0 || &(*r).FR[ds][0] != &(*mPtr).rates[ds][0]) {
+			copy((*mPtr).rates[ds], (*r).FR[ds])
+			(*r).FR[ds] = (*mPtr).rates[ds]
+		}
+
+	}
+
+}
+
+func (mPtr *MappedFile) Update(updateDataPoint []*float64) (error) {
+
+	// rrd.UpdateErr in MappedFile.Write
+
+	return (*mPtr).UpdateAt(rrd_now(&(*mPtr).Rrd), updateDataPoint)
+
+}
+
+func (mPtr *MappedFile) UpdateAt(updateTimeStamp time.Time, updateDataPoint []*float64) (error) {
+
+	// rrd.UpdateAt in MappedFile.Write
+
+	if (uint64(len(updateDataPoint)) > (*mPtr).DataPointsSpace) {
+		return fmt.Errorf("%w, the update has %d values and the file has space for %d", ErrFileFull, len(updateDataPoint), (*mPtr).DataPointsSpace)
+	}
+
+	return (*mPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateAt(updateTimeStamp, updateDataPoint, rrdPtr)
+	})
+
+}
+
+func (mPtr *MappedFile) Sync() (error) {
+
+	// commit the mapping to storage
+
+	return (*mPtr).file.Sync()
+
+}
+
+func (mPtr *MappedFile) Close() (error) {
+
+	// unmap and close the file
+
+	var err error
+
+	if ((*mPtr).data != nil) {
+		err = syscall.Munmap((*mPtr).data)
+		(*mPtr).data = nil
+	}
+
+	(*mPtr).Rrd.FD = nil
+	(*mPtr).Rrd.FR = nil
+	(*mPtr).Rrd.stored = false
+	(*mPtr).values = nil
+	(*mPtr).rates = nil
+
+	return errors.Join(err, (*mPtr).file.Close())
+
+}
@@ -0,0 +1,235 @@
+//go:build linux
+
+package rrd
+
+import (
+	"time"
+	"sync"
+	"errors"
+	"testing"
+	"path/filepath"
+)
+
+func create_mapped_test_file(t *testing.T) (string, time.Time) {
+
+	// a Gauge file with 4 data points that are 0 at base
+
+	var base = time.Unix(1700000000, 0)
+	var path = filepath.Join(t.TempDir(), "test.rrd")
+
+	var r = Rrd{Interval: time.Second, TotalSteps: 16, DataType: Gauge}
+
+	var err = UpdateFloatAt(base, []float64{0, 0, 0, 0}, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	f, err := CreateFile(path, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	return path, base
+
+}
+
+func check_mapped_read(rrdPtr *Rrd, base time.Time) (string) {
+
+	// return why the Rrd is torn or an empty string
+	// update n of the writer sets every data point to n at base plus n seconds
+
+	var n = float64((*rrdPtr).LastUpdate.Sub(base) / time.Second)
+	var last_step = uint64(step_at(rrdPtr, (*rrdPtr).LastUpdate))
+
+	if v, known := (*rrdPtr).Value(last_step, 0); known == false || v != n {
+		return "the value of the step of LastUpdate " + (*rrdPtr).LastUpdate.String() + " is not the update at LastUpdate"
+	}
+
+	for step := uint64(0); step <= last_step; step++ {
+
+		var first, first_known = (*rrdPtr).Value(step, 0)
+
+		for ds := 1; ds < (*rrdPtr).DataPoints(); ds++ {
+
+			var v, known = (*rrdPtr).Value(step, ds)
+			if (known != first_known || (known == true && v != first)) {
+				return "the data points of a step are from different updates"
+			}
+
+		}
+
+	}
+
+	return ""
+
+}
+
+func check_mapped_reads(t *testing.T, path string, base time.Time, update func(n int, values []*float64) (error)) {
+
+	// goroutines Read one MappedFile until it was updated 2000 times
+	// a reader must never see data points of different updates or a LastUpdate without its values
+
+	var reader, err = MapFile(path, nil, false)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	reader.ReadTimeout = time.Minute
+
+	var done = make(chan struct{})
+	var wg sync.WaitGroup
+
+	for range 4 {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for {
+
+				var torn string
+
+				var err = reader.Read(func(rrdPtr *Rrd) {
+					torn = check_mapped_read(rrdPtr, base)
+				})
+
+				if (err != nil) {
+					t.Error(err)
+					return
+				}
+
+				if (torn != "") {
+					t.Error(torn)
+					return
+				}
+
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+			}
+
+		}()
+
+	}
+
+	for n := 1; n <= 2000; n++ {
+
+		var v = float64(n)
+		err = update(n, []*float64{&v, &v, &v, &v})
+		if (err != nil) {
+			t.Error(err)
+			break
+		}
+
+	}
+
+	close(done)
+	wg.Wait()
+
+}
+
+func TestMappedFileConcurrentRead(t *testing.T) {
+
+	var path, base = create_mapped_test_file(t)
+
+	var writer, err = MapFile(path, nil, true)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	check_mapped_reads(t, path, base, func(n int, values []*float64) (error) {
+		return writer.UpdateAt(base.Add(time.Duration(n) * time.Second), values)
+	})
+
+}
+
+func TestMappedFileConcurrentReadOfFile(t *testing.T) {
+
+	// File.Write writes the slots of each data point and the header with separate writes
+
+	var path, base = create_mapped_test_file(t)
+
+	var writer, err = OpenFile(path, nil)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	check_mapped_reads(t, path, base, func(n int, values []*float64) (error) {
+		return writer.UpdateAt(base.Add(time.Duration(n) * time.Second), values)
+	})
+
+}
+
+func TestMappedFileStoppedWriter(t *testing.T) {
+
+	// a writer that stopped during a write leaves the generation odd
+
+	var path, _ = create_mapped_test_file(t)
+
+	var stopped, err = MapFile(path, nil, true)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	stopped.Write(func(rrdPtr *Rrd) (error) {
+
+		// the generation is odd while fn runs
+
+		var reader, err = MapFile(path, nil, false)
+		if (err != nil) {
+			t.Error(err)
+			return nil
+		}
+		defer reader.Close()
+
+		reader.ReadTimeout = 50 * time.Millisecond
+
+		err = reader.Read(func(rrdPtr *Rrd) {})
+		if (errors.Is(err, ErrFileBusy) == false) {
+			t.Errorf("Read during a write returned %v, want ErrFileBusy", err)
+		}
+
+		// a new writer does not accept the values of the stopped write
+		_, err = MapFile(path, nil, true)
+		if (errors.Is(err, ErrFileBusy) == false) {
+			t.Errorf("MapFile of a new writer during a write returned %v, want ErrFileBusy", err)
+		}
+
+		// File.Write accepts the values in the file and makes the generation even
+		f, err := OpenFile(path, nil)
+		if (err != nil) {
+			t.Error(err)
+			return nil
+		}
+
+		err = f.Close()
+		if (err != nil) {
+			t.Error(err)
+			return nil
+		}
+
+		err = reader.Read(func(rrdPtr *Rrd) {})
+		if (err != nil) {
+			t.Errorf("Read after File.Write returned %v", err)
+		}
+
+		return nil
+
+	})
+
+	stopped.Close()
+
+}
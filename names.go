@@ -0,0 +1,140 @@
+package rrd
+
+import (
+	"math"
+	"errors"
+	"slices"
+	"time"
+)
+
+func (rrdPtr *Rrd) Index(name string) (int, bool) {
+
+	// return the index of the data point with name
+	// a data point without a name is not found by the empty string
+
+	if (name == "") {
+		return -1, false
+	}
+
+	for e := range (*rrdPtr).DataSources {
+		if ((*rrdPtr).DataSources[e].Name == name) {
+			return e, true
+		}
+	}
+
+	return -1, false
+
+}
+
+func (rrdPtr *Rrd) Names() ([]string) {
+
+	// return the name of each data point, a data point without a name is an empty string
+
+	var names = make([]string, (*rrdPtr).DataPoints())
+
+	for e := range (*rrdPtr).DataSources {
+		if (e < len(names)) {
+			names[e] = (*rrdPtr).DataSources[e].Name
+		} else {
+			names = append(names, (*rrdPtr).DataSources[e].Name)
+		}
+	}
+
+	return names
+
+}
+
+func AvgByName(name string, rrdPtrs ...*Rrd) (float64) {
+
+	// Avg of the data point with name
+	// a Rrd without a data point with name is skipped
+
+	return avg(func(rrdPtr *Rrd) (int, bool) { return (*rrdPtr).Index(name) }, rrdPtrs)
+
+}
+
+func UpdateMap(values map[string]float64, rrdPtr *Rrd) (error) {
+
+	// update the Rrd with values by name, with the time of execution
+
+	return UpdateMapAt(rrd_now(rrdPtr), values, rrdPtr)
+
+}
+
+func UpdateMapAt(updateTimeStamp time.Time, values map[string]float64, rrdPtr *Rrd) (error) {
+
+	// update the Rrd with values by name
+	// a data point with a name that is not in values is nil
+	// a name that is not in Rrd.DataSources is added as a new data point after the existing data points
+
+	if (values == nil) {
+		return nil
+	}
+
+	// the data points that exist before this update
+	var data_points = (*rrdPtr).DataPoints()
+	if (len((*rrdPtr).DataSources) > data_points) {
+		data_points = len((*rrdPtr).DataSources)
+	}
+
+	// new names are added in order so the index of each is the same with any map order
+	var new_names []string
+
+	for name := range values {
+
+		if (name == "") {
+			// an empty name is not a data point, a data point without a name is updated by index with UpdateFloatAt
+			return errors.New("the update has a value with an empty data point name")
+		}
+
+		if _, found := (*rrdPtr).Index(name); found == false {
+			new_names = append(new_names, name)
+		}
+
+	}
+
+	slices.Sort(new_names)
+
+	var update = make([]float64, data_points + len(new_names))
+
+	for e := range update {
+		update[e] = math.NaN()
+	}
+
+	for e := range (*rrdPtr).DataSources {
+
+		if ((*rrdPtr).DataSources[e].Name == "") {
+			continue
+		}
+
+		if v, ok := values[(*rrdPtr).DataSources[e].Name]; ok == true {
+			update[e] = v
+		}
+
+	}
+
+	for n := range new_names {
+		update[data_points + n] = values[new_names[n]]
+	}
+
+	var err = UpdateFloatAt(updateTimeStamp, update, rrdPtr)
+	if (err != nil) {
+		return err
+	}
+
+	if (len(new_names) > 0) {
+
+		// the update extended MinimumDataPoints, name the new data points
+		for (len((*rrdPtr).DataSources) < data_points) {
+			(*rrdPtr).DataSources = append((*rrdPtr).DataSources, DataSource{})
+		}
+
+		for n := range new_names {
+			(*rrdPtr).DataSources = append((*rrdPtr).DataSources, DataSource{Name: new_names[n]})
+		}
+
+	}
+
+	return nil
+
+}
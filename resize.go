@@ -0,0 +1,305 @@
+package rrd
+
+import (
+	"fmt"
+	"time"
+)
+
+// Resize and Resample change the TotalSteps and Interval of a Rrd that has data
+// the storage is rebuilt in the Storage layout of the Rrd
+// the Rrd of a File or MappedFile can not be changed, Resize or Resample a copy of the Rrd and store it with CreateFile
+
+func Resize(rrdPtr *Rrd, totalSteps uint64) (error) {
+
+	// change the TotalSteps of the Rrd, the most recent steps are kept
+	// when totalSteps is less than the steps up to LastUpdate the oldest steps are removed and FirstUpdateTs is moved forward
+
+	if (totalSteps == 0) {
+		return fmt.Errorf("totalSteps must be more than 0")
+	}
+
+	if ((*rrdPtr).stored == true) {
+		return fmt.Errorf("%w, the TotalSteps of the Rrd of a File can not be changed", ErrInvalidFile)
+	}
+
+	if ((*rrdPtr).FirstUpdateTs == nil || ((*rrdPtr).D == nil && (*rrdPtr).FD == nil)) {
+		// there is no data, the first update allocates TotalSteps
+		(*rrdPtr).TotalSteps = totalSteps
+		return nil
+	}
+
+	// the step of LastUpdate is the most recent step with data
+	var last_step = uint64(step_at(rrdPtr, (*rrdPtr).LastUpdate))
+
+	// the first step that is kept
+	var first_step = uint64(0)
+	if (last_step + 1 > totalSteps) {
+		first_step = last_step + 1 - totalSteps
+	}
+
+	var r = resized(rrdPtr, totalSteps, (*rrdPtr).Interval, step_time(rrdPtr, first_step))
+
+	for n := first_step; n <= last_step; n++ {
+
+		for ds := 0; ds < (*rrdPtr).DataPoints(); ds++ {
+
+			if v, known := (*rrdPtr).Value(n, ds); known == true {
+				r.SetValue(n - first_step, ds, v)
+			}
+
+			if v, known := (*rrdPtr).Rate(n, ds); known == true {
+				r.SetRate(n - first_step, ds, v)
+			}
+
+		}
+
+	}
+
+	replace_steps(rrdPtr, &r)
+
+	// the rate of the first step was from a step that is removed
+	RecalculateRate(rrdPtr)
+
+	return nil
+
+}
+
+// the consolidation of the old steps within a new step of Resample
+type resample_step struct {
+	v			float64
+	// the sum of the value multiplied by the seconds of each old step within the new step, used by rrd.Average
+	sum			float64
+	seconds			float64
+	known			bool
+}
+
+func Resample(rrdPtr *Rrd, interval time.Duration) (error) {
+
+	// change the Interval of the Rrd, TotalSteps is changed so the Rrd stores the same time
+	// each new step is the consolidation of the old steps within it, an old step in more than one new step is divided by the time in each
+	// 	Gauge - Rrd.Consolidation of the old steps, rrd.Average is weighted by the time of each old step within the new step and rrd.Sum is divided
+	// 	Counter and Derive - the last counter within the new step, the rates are calculated again by RecalculateRate
+	// 		the counter of each old step is at the time within the step of LastUpdate
+	// 	Absolute - the sum of the amount of each old step within the new step, an old step in more than one new step is divided by the time in each, the rates are calculated again by RecalculateRate
+	// with rrd.AlignEpoch the new steps are aligned to the new Interval
+
+	if (interval <= 0) {
+		return fmt.Errorf("interval must be more than 0")
+	}
+
+	if ((*rrdPtr).stored == true) {
+		return fmt.Errorf("%w, the Interval of the Rrd of a File can not be changed", ErrInvalidFile)
+	}
+
+	var location, location_err = time_zone(rrdPtr)
+	if (location_err != nil) {
+		return location_err
+	}
+
+	var old_interval = (*rrdPtr).Interval
+
+	// the same time in steps of interval
+	var total_steps = uint64((time.Duration((*rrdPtr).TotalSteps) * old_interval + interval - 1) / interval)
+	if (total_steps == 0) {
+		total_steps = 1
+	}
+
+	if ((*rrdPtr).FirstUpdateTs == nil || ((*rrdPtr).D == nil && (*rrdPtr).FD == nil)) {
+		// there is no data, the first update allocates TotalSteps
+		(*rrdPtr).Interval = interval
+		(*rrdPtr).TotalSteps = total_steps
+		return nil
+	}
+
+	// the new steps start at FirstUpdateTs and end with the step of LastUpdate
+	var first = (*(*rrdPtr).FirstUpdateTs)
+	if ((*rrdPtr).Alignment == AlignEpoch) {
+		first = align_step(first, interval, location)
+	}
+
+	var last_step = uint64((*rrdPtr).LastUpdate.Sub(first) / interval)
+	if (last_step + 1 > total_steps) {
+		// the most recent steps are kept
+		first = first.Add(interval * time.Duration(last_step + 1 - total_steps))
+		last_step = total_steps - 1
+	}
+
+	var data_points = (*rrdPtr).DataPoints()
+
+	var steps = make([][]resample_step, data_points)
+	for ds := range steps {
+		steps[ds] = make([]resample_step, total_steps)
+	}
+
+	var old_last_step = uint64(step_at(rrdPtr, (*rrdPtr).LastUpdate))
+
+	// the end of the new step of LastUpdate
+	var new_end = first.Add(interval * time.Duration(last_step + 1))
+
+	// the time of a counter within its step is not stored, each counter is at the time in its step of LastUpdate
+	var counter_offset = (*rrdPtr).LastUpdate.Sub(step_time(rrdPtr, old_last_step))
+
+	for n := uint64(0); n <= old_last_step; n++ {
+
+		var start = step_time(rrdPtr, n)
+		var end = start.Add(old_interval)
+		var old_seconds = old_interval.Seconds()
+
+		if (end.Compare(first) <= 0) {
+			// the old step is before the new steps
+			continue
+		}
+
+		// the new steps of the old step
+		var first_new = max(int64(0), int64(start.Sub(first) / interval))
+		var last_new = int64((end.Sub(first) - 1) / interval)
+
+		var counter_ts = start.Add(counter_offset)
+
+		if (n == old_last_step) {
+			// the old step of LastUpdate is not after the new step of LastUpdate
+			last_new = int64(last_step)
+			if (new_end.Before(end)) {
+				old_seconds = new_end.Sub(start).Seconds()
+			}
+		}
+
+		for ds := 0; ds < data_points; ds++ {
+
+			var v, known = (*rrdPtr).Value(n, ds)
+			if (known == false) {
+				continue
+			}
+
+			if ((*rrdPtr).DataType == Counter || (*rrdPtr).DataType == Derive) {
+
+				if (counter_ts.Before(first) == false) {
+					// a later counter in the same new step replaces it
+					steps[ds][counter_ts.Sub(first) / interval] = resample_step{v: v, known: true}
+				}
+
+				continue
+
+			}
+
+			for s := first_new; s <= last_new; s++ {
+
+				var step_start = first.Add(interval * time.Duration(s))
+				if (step_start.Before(start)) {
+					step_start = start
+				}
+
+				var step_end = first.Add(interval * time.Duration(s + 1))
+				if (step_end.After(end)) {
+					step_end = end
+				}
+
+				// the time of the old step within the new step
+				var seconds = step_end.Sub(step_start).Seconds()
+				if (seconds <= 0) {
+					continue
+				}
+
+				resample_add(&steps[ds][s], (*rrdPtr).DataType, (*rrdPtr).Consolidation, v, seconds, old_seconds)
+
+			}
+
+		}
+
+	}
+
+	var r = resized(rrdPtr, total_steps, interval, first)
+
+	for ds := range steps {
+
+		for s := range steps[ds] {
+
+			var step = steps[ds][s]
+			if (step.known == false) {
+				continue
+			}
+
+			if ((*rrdPtr).DataType == Gauge && (*rrdPtr).Consolidation == Average) {
+				step.v = step.sum / step.seconds
+			}
+
+			r.SetValue(uint64(s), ds, step.v)
+
+		}
+
+	}
+
+	replace_steps(rrdPtr, &r)
+
+	// the step of LastUpdate is one update
+	(*rrdPtr).CurrentAvgCount = 1
+	(*rrdPtr).CurrentAvgTime = (*rrdPtr).LastUpdate.Sub(step_time(rrdPtr, last_step))
+
+	RecalculateRate(rrdPtr)
+
+	return nil
+
+}
+
+func resample_add(stepPtr *resample_step, dataType uint8, consolidation uint8, v float64, seconds float64, old_seconds float64) {
+
+	// consolidate the value of an old step that is in the new step for seconds
+
+	if (dataType == Absolute || (dataType == Gauge && consolidation == Sum)) {
+
+		// the amount of the old step within the new step
+		v = v * seconds / old_seconds
+
+		if ((*stepPtr).known == true) {
+			v += (*stepPtr).v
+		}
+
+	} else if (dataType == Gauge && consolidation == Average) {
+
+		(*stepPtr).sum += v * seconds
+		(*stepPtr).seconds += seconds
+
+	} else if ((*stepPtr).known == true) {
+
+		// rrd.Min, rrd.Max or rrd.Last
+		v = consolidate(consolidation, (*stepPtr).v, v, 1)
+
+	}
+
+	(*stepPtr).v = v
+	(*stepPtr).known = true
+
+}
+
+func resized(rrdPtr *Rrd, totalSteps uint64, interval time.Duration, first time.Time) (Rrd) {
+
+	// return an empty Rrd with the storage of rrdPtr for totalSteps of interval from first
+
+	var r Rrd
+	r.Interval = interval
+	r.TotalSteps = totalSteps
+	r.DataType = (*rrdPtr).DataType
+	r.Storage = (*rrdPtr).Storage
+	r.MinimumDataPoints = (*rrdPtr).MinimumDataPoints
+	r.FirstUpdateTs = &first
+
+	reset_storage(&r, (*rrdPtr).DataPoints())
+
+	return r
+
+}
+
+func replace_steps(rrdPtr *Rrd, rPtr *Rrd) {
+
+	// replace the steps of rrdPtr with the steps of rPtr
+
+	(*rrdPtr).Interval = (*rPtr).Interval
+	(*rrdPtr).TotalSteps = (*rPtr).TotalSteps
+	(*rrdPtr).FirstUpdateTs = (*rPtr).FirstUpdateTs
+	(*rrdPtr).Head = 0
+	(*rrdPtr).D = (*rPtr).D
+	(*rrdPtr).R = (*rPtr).R
+	(*rrdPtr).FD = (*rPtr).FD
+	(*rrdPtr).FR = (*rPtr).FR
+
+}
@@ -0,0 +1,184 @@
+package rrd
+
+import (
+	"math"
+	"time"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+
+	// the steps are [3, 4, 5, 6] from 2s with Head 2
+
+	var updates = []test_update{{0, 1}, {time.Second, 2}, {2 * time.Second, 3}, {3 * time.Second, 4}, {4 * time.Second, 5}, {5 * time.Second, 6}}
+	var nan = math.NaN()
+
+	for _, storage := range []uint8{Pointer, Flat} {
+
+		// growing keeps every step and FirstUpdateTs, the new steps are after LastUpdate
+		var grown = test_rrd(t, storage, 4, updates)
+
+		var err = Resize(&grown, 8)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		check_ring(t, &grown, 2 * time.Second, 0, []float64{3, 4, 5, 6, nan, nan, nan, nan})
+
+		// the next steps do not shift until the 8 steps are used
+		test_updates(t, &grown, []test_update{{9 * time.Second, 10}})
+		check_ring(t, &grown, 2 * time.Second, 0, []float64{3, 4, 5, 6, nan, nan, nan, 10})
+
+		// shrinking keeps the newest steps and moves FirstUpdateTs forward
+		var shrunk = test_rrd(t, storage, 4, updates)
+
+		err = Resize(&shrunk, 2)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		check_ring(t, &shrunk, 4 * time.Second, 0, []float64{5, 6})
+
+		test_updates(t, &shrunk, []test_update{{6 * time.Second, 7}})
+		check_ring(t, &shrunk, 5 * time.Second, 1, []float64{6, 7})
+
+	}
+
+}
+
+func TestResampleGauge(t *testing.T) {
+
+	// steps of 2s with the values 10 to 60 are resampled to steps of 3s, each odd old step is in two new steps
+
+	var want = map[uint8][]float64{
+		// the values weighted by the seconds of each old step within the new step
+		Average: {(10 * 2 + 20) / 3.0, (20 + 30 * 2) / 3.0, (40 * 2 + 50) / 3.0, (50 + 60 * 2) / 3.0},
+		// the values divided by the seconds of each old step within the new step
+		Sum: {10 + 20 / 2.0, 20 / 2.0 + 30, 40 + 50 / 2.0, 50 / 2.0 + 60},
+		Max: {20, 30, 50, 60},
+	}
+
+	for consolidation, values := range want {
+
+		for _, storage := range []uint8{Pointer, Flat} {
+
+			var r = Rrd{Interval: 2 * time.Second, TotalSteps: 6, DataType: Gauge, Storage: storage, Consolidation: consolidation}
+
+			for n := 0; n < 6; n++ {
+				test_updates(t, &r, []test_update{{time.Duration(n) * 2 * time.Second, float64(n + 1) * 10}})
+			}
+
+			var err = Resample(&r, 3 * time.Second)
+			if (err != nil) {
+				t.Fatal(err)
+			}
+
+			if (r.Interval != 3 * time.Second || r.TotalSteps != 4) {
+				t.Fatalf("Interval %s and TotalSteps %d, want 3s and 4", r.Interval, r.TotalSteps)
+			}
+
+			check_ring(t, &r, 0, 0, values)
+
+		}
+
+	}
+
+}
+
+func TestResampleCounter(t *testing.T) {
+
+	// the counter of each new step is the last counter within it and the rates are calculated again by RecalculateRate
+
+	var counter = func(n int) (float64) {
+		return float64(n * n * 10)
+	}
+
+	for _, storage := range []uint8{Pointer, Flat} {
+
+		var r = Rrd{Interval: time.Second, TotalSteps: 8, DataType: Counter, Storage: storage}
+
+		for n := 0; n < 8; n++ {
+
+			var err = UpdateFloatAt(test_base.Add(time.Duration(n) * time.Second + 500 * time.Millisecond), []float64{counter(n)}, &r)
+			if (err != nil) {
+				t.Fatal(err)
+			}
+
+		}
+
+		var err = Resample(&r, 2 * time.Second)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		if (r.TotalSteps != 4) {
+			t.Fatalf("TotalSteps %d, want 4", r.TotalSteps)
+		}
+
+		for s := uint64(0); s < 4; s++ {
+
+			// the counter of the old step 2s + 1 is at 2s + 1.5 seconds
+			var v, known = r.Value(s, 0)
+			if (known == false || v != counter(int(s) * 2 + 1)) {
+				t.Errorf("the counter of step %d is %v known %t, want %v", s, v, known, counter(int(s) * 2 + 1))
+			}
+
+			// the first counter has no previous counter
+			var want = math.NaN()
+			if (s > 0) {
+				want = (counter(int(s) * 2 + 1) - counter(int(s) * 2 - 1)) / 2
+			}
+
+			var rate, rate_known = r.Rate(s, 0)
+			if (rate_known == math.IsNaN(want) || (rate_known == true && rate != want)) {
+				t.Errorf("the rate of step %d is %v known %t, want %v", s, rate, rate_known, want)
+			}
+
+		}
+
+	}
+
+}
+
+func TestResampleAbsolute(t *testing.T) {
+
+	// the amount of each new step is the sum of the amount of each old step within it, an old step in two new steps is divided by the time in each
+
+	var r = Rrd{Interval: 2 * time.Second, TotalSteps: 6, DataType: Absolute}
+
+	for n := 0; n < 6; n++ {
+
+		var err = UpdateFloatAt(test_base.Add(time.Duration(n) * 2 * time.Second + time.Second), []float64{float64(n + 1) * 10}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	var old = make([]float64, 6)
+	for n := range old {
+		old[n], _ = r.Value(uint64(n), 0)
+	}
+
+	var err = Resample(&r, 3 * time.Second)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	var want = []float64{old[0] + old[1] / 2, old[1] / 2 + old[2], old[3] + old[4] / 2, old[4] / 2 + old[5]}
+
+	for s := range want {
+
+		var v, known = r.Value(uint64(s), 0)
+		if (known == false || math.Abs(v - want[s]) > 1e-9) {
+			t.Errorf("the amount of step %d is %v known %t, want %v", s, v, known, want[s])
+		}
+
+		var rate, _ = r.Rate(uint64(s), 0)
+		if (s > 0 && math.Abs(rate - want[s] / 3) > 1e-9) {
+			t.Errorf("the rate of step %d is %v, want %v", s, rate, want[s] / 3)
+		}
+
+	}
+
+}
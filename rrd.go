@@ -27,7 +27,6 @@ import (
 	"sync"
 	"os"
 	"context"
-	"strings"
 )
 
 const (
@@ -40,17 +39,64 @@ const (
 	// rrd types
 	Counter uint8 = 0
 	Gauge uint8 = 1
+	Derive uint8 = 2
+	Absolute uint8 = 3
 
 	// token queue types
 	Working uint8 = 0
 	Instant uint8 = 1
 
+	// storage layouts
+	Pointer uint8 = 0
+	Flat uint8 = 1
+
+	// counter decrease policies
+	DecreaseWrap uint8 = 0
+	DecreaseReset uint8 = 1
+	DecreaseUnknown uint8 = 2
+
+	// consolidation functions
+	Average uint8 = 0
+	Min uint8 = 1
+	Max uint8 = 2
+	Last uint8 = 3
+	Sum uint8 = 4
+
+	// step alignments
+	AlignFirstUpdate uint8 = 0
+	AlignEpoch uint8 = 1
+
+	// Gauge averaging weights
+	WeightUpdate uint8 = 0
+	WeightTime uint8 = 1
+
+	// gap policies
+	GapReset uint8 = 0
+	GapKeep uint8 = 1
+
+	// clock jump policies
+	ClockReject uint8 = 0
+	ClockClamp uint8 = 1
+	ClockRebase uint8 = 2
+	// the ClockJump.Policy when the monotonic clock was used
+	ClockMonotonic uint8 = 3
+
+	// dump formats
+	DumpTable uint8 = 0
+	DumpJSON uint8 = 1
+	DumpHuman uint8 = 2
+
 )
 
 type Rrd struct {
 	D			[][]*float64	`xyzdb:"D" bson:"D" json:"D"`
 	R			[][]*float64	`xyzdb:"R" bson:"R" json:"R"`
+	// D and R of the Flat layout, FD[data point][step] with NaN for unknown values
+	FD			[]Series	`xyzdb:"FD" bson:"FD" json:"FD,omitempty"`
+	FR			[]Series	`xyzdb:"FR" bson:"FR" json:"FR,omitempty"`
 	CurrentAvgCount		int64		`xyzdb:"CurrentAvgCount" bson:"CurrentAvgCount" json:"CurrentAvgCount"`
+	// the time the averaged values of the step that contains LastUpdate were in effect, used by rrd.WeightTime
+	CurrentAvgTime		time.Duration	`xyzdb:"CurrentAvgTime" bson:"CurrentAvgTime" json:"CurrentAvgTime"`
 	FirstUpdateTs		*time.Time	`xyzdb:"FirstUpdateTs" bson:"FirstUpdateTs" json:"FirstUpdateTs"`
 	LastUpdateDataPoint	[]*float64	`xyzdb:"LastUpdateDataPoint" bson:"LastUpdateDataPoint" json:"LastUpdateDataPoint"`
 	LastUpdate		time.Time	`xyzdb:"LastUpdate" bson:"LastUpdate" json:"LastUpdate"`
@@ -58,7 +104,94 @@ type Rrd struct {
 	Interval		time.Duration	`xyzdb:"Interval" bson:"Interval" json:"Interval"`
 	TotalSteps		uint64		`xyzdb:"TotalSteps" bson:"TotalSteps" json:"TotalSteps"`
 	DataType		uint8		`xyzdb:"DataType" bson:"DataType" json:"DataType"`
+	// the index in D and R of the step at FirstUpdateTs, D and R are a ring of TotalSteps
+	Head			uint64		`xyzdb:"Head" bson:"Head" json:"Head"`
+	// rrd.Pointer stores values in D and R, rrd.Flat stores values in FD and FR
+	Storage			uint8		`xyzdb:"Storage" bson:"Storage" json:"Storage"`
+	// how Gauge updates within a step are combined, rrd.Average, rrd.Min, rrd.Max, rrd.Last or rrd.Sum
+	Consolidation		uint8		`xyzdb:"Consolidation" bson:"Consolidation" json:"Consolidation"`
+	// how rrd.Average weights the Gauge updates of a step, rrd.WeightUpdate or rrd.WeightTime
+	// 	rrd.WeightUpdate - each update has the same weight
+	// 	rrd.WeightTime - each value is weighted by the time it was in effect, from the previous update to the update like rrdtool
+	Weighting		uint8		`xyzdb:"Weighting" bson:"Weighting" json:"Weighting"`
+	// where steps start, rrd.AlignFirstUpdate or rrd.AlignEpoch
+	// 	rrd.AlignFirstUpdate - the first step starts at the first update
+	// 	rrd.AlignEpoch - steps start at multiples of Interval since the Unix epoch in TimeZone, Rrd with the same Interval have the same steps
+	Alignment		uint8		`xyzdb:"Alignment" bson:"Alignment" json:"Alignment"`
+	// the IANA time zone name of rrd.AlignEpoch like America/New_York so steps of a day start at midnight, UTC when empty
+	TimeZone		string		`xyzdb:"TimeZone" bson:"TimeZone" json:"TimeZone"`
+	// how a Counter that decreases is handled
+	CounterPolicy		CounterPolicy	`xyzdb:"CounterPolicy" bson:"CounterPolicy" json:"CounterPolicy"`
+	// what an update more than TotalSteps * 2 steps after FirstUpdateTs does, rrd.GapReset or rrd.GapKeep
+	// 	rrd.GapReset - all the data is replaced, the update is the first update
+	// 	rrd.GapKeep - the data is shifted like a shorter gap, the steps of the gap are unknown like updates without values at each Interval since the previous update
+	GapPolicy		uint8		`xyzdb:"GapPolicy" bson:"GapPolicy" json:"GapPolicy"`
+	// how an update before LastUpdate or far after LastUpdate is handled
+	ClockPolicy		ClockPolicy	`xyzdb:"ClockPolicy" bson:"ClockPolicy" json:"ClockPolicy"`
+	// the definition of each data point by index
+	DataSources		[]DataSource	`xyzdb:"DataSources" bson:"DataSources" json:"DataSources"`
 	Debug			bool		`json:"-"`
+	// returns the time used by Update, time.Now when nil
+	Clock			func() time.Time	`xyzdb:"-" bson:"-" json:"-"`
+	// called with each clock regression or forward jump and how it was handled
+	OnClockJump		func(ClockJump)	`xyzdb:"-" bson:"-" json:"-"`
+	// called with each slot of D, R, FD or FR that is written, used by File to write only the changed slots
+	dirty			func(slot uint64)
+	// true for the Rrd of a File or MappedFile, the Interval and TotalSteps do not change
+	stored			bool
+	// called with the shift of FirstUpdateTs and LastUpdate by rrd.ClockRebase, used by RrdSet to shift the archives
+	rebased			func(shift time.Duration)
+}
+
+var (
+	// the locations of Rrd.TimeZone by name
+	time_zones sync.Map
+)
+
+var (
+	// returned when an update has fewer values than Rrd.MinimumDataPoints
+	ErrTooFewDataPoints = errors.New("too few data points")
+	// returned when a TokenQueue function is used with the wrong TokenQueue.Type
+	ErrWrongQueueType = errors.New("wrong TokenQueue.Type")
+	// returned when GetUpdateValuesErr is given a value that is not float64, *float64 or nil
+	ErrUnsupportedValue = errors.New("unsupported value")
+)
+
+// how a Counter that decreases is handled
+type CounterPolicy struct {
+	// rrd.DecreaseWrap, rrd.DecreaseReset or rrd.DecreaseUnknown
+	// 	rrd.DecreaseWrap - the counter wrapped at Width
+	// 	rrd.DecreaseReset - the counter was reset to zero, the increase is the current value
+	// 	rrd.DecreaseUnknown - the rate is unknown
+	OnDecrease		uint8		`xyzdb:"OnDecrease" bson:"OnDecrease" json:"OnDecrease"`
+	// 32 or 64, 0 wraps when the previous value was between 70% and 100% of the 32 or 64 bit limit
+	Width			uint8		`xyzdb:"Width" bson:"Width" json:"Width"`
+	// the highest plausible rate per second, a higher rate is unknown, 0 has no limit
+	MaxRate			float64		`xyzdb:"MaxRate" bson:"MaxRate" json:"MaxRate"`
+}
+
+// the definition of a data point
+type DataSource struct {
+	// the name of the data point used by UpdateMap, Index and AvgByName
+	Name			string		`xyzdb:"Name" bson:"Name" json:"Name"`
+	// replaces Rrd.CounterPolicy for this data point when not nil
+	CounterPolicy		*CounterPolicy	`xyzdb:"CounterPolicy" bson:"CounterPolicy" json:"CounterPolicy"`
+	// the longest time between values of a Counter or Derive before the rate between them is unknown, 0 has no limit
+	// with rrd.WeightTime a Gauge value is not in effect before the update when the time since the previous update is longer
+	Heartbeat		time.Duration	`xyzdb:"Heartbeat" bson:"Heartbeat" json:"Heartbeat"`
+	// the range of a Gauge value or the rate of a Counter, Derive or Absolute, values outside the range are unknown
+	Min			*float64	`xyzdb:"Min" bson:"Min" json:"Min"`
+	Max			*float64	`xyzdb:"Max" bson:"Max" json:"Max"`
+}
+
+// returned by UpdateAt when the update is older than Rrd.LastUpdate
+type UpdateTooOldError struct {
+	Ts			time.Time
+	LastUpdate		time.Time
+}
+
+func (e *UpdateTooOldError) Error() (string) {
+	return "update at " + e.Ts.String() + " is older than LastUpdate " + e.LastUpdate.String()
 }
 
 type Interpolation struct {
@@ -309,69 +442,63 @@ func Avg(index int, rrdPtrs ...*Rrd) (float64) {
 	// if there are multiple Rrd, return the highest value
 	// this is explained best in TokenQueue using multiple RRD to track changes
 
+	return avg(func(rrdPtr *Rrd) (int, bool) { return index, true }, rrdPtrs)
+
+}
+
+func avg(index func(*Rrd) (int, bool), rrdPtrs []*Rrd) (float64) {
+
+	// return the highest average of the values at index of each Rrd
+	// a Rrd is skipped when index returns false
+
 	var highest_avg float64
+	var first = true
 
 	for l := range rrdPtrs {
 
 		var rrdPtr = rrdPtrs[l]
 
-		if ((*rrdPtr).D == nil || (*rrdPtr).D[0] == nil) {
+		if ((*rrdPtr).FirstUpdateTs == nil) {
 			// no data
 			// proceed to next rrdPtr
 			continue
 		}
 
-		var avg float64
-		var count float64
-
-		if ((*rrdPtr).R != nil) {
-
-			// this is a Gauge rrd
-
-			for n := range (*rrdPtr).R {
-
-				if (len((*rrdPtr).R[n]) < index + 1) {
-					// the rate of this interval has not been calculated yet
-					continue
-				}
+		var e, found = index(rrdPtr)
+		if (found == false) {
+			continue
+		}
 
-				var r_value_pointer = (*rrdPtr).R[n][index]
+		var values = (*rrdPtr).Series(e)
+		if ((*rrdPtr).HasRates() == true) {
+			// this is a Counter, Derive or Absolute rrd, use the rate
+			values = (*rrdPtr).RateSeries(e)
+		}
 
-				if (r_value_pointer == nil) {
-					continue
-				}
+		var avg float64
+		var count float64
 
-				avg += (*r_value_pointer)
-				count += 1
+		for _, v := range values {
 
+			if (math.IsNaN(v)) {
+				continue
 			}
 
-		} else {
-
-			// this is a Counter rrd
-
-			for n := range (*rrdPtr).D {
-
-				var d_value_pointer = (*rrdPtr).D[n][index]
-
-				if (d_value_pointer == nil) {
-					continue
-				}
-
-				avg += (*d_value_pointer)
-				count += 1
-			}
+			avg += v
+			count += 1
 
 		}
 
-		if (avg > 0) {
+		if (count > 0) {
 
+			// a Derive rate can be negative
 			avg = avg / count
 
 		}
 
-		if (l == 0 || avg > highest_avg) {
+		if (first == true || avg > highest_avg) {
 			highest_avg = avg
+			first = false
 		}
 
 	}
@@ -382,79 +509,59 @@ func Avg(index int, rrdPtrs ...*Rrd) (float64) {
 
 func Dump(rrdPtr *Rrd) {
 
-	if (rrdPtr == nil) {
-		fmt.Println("rrdPtr is nil.")
-		return
-	} else if ((*rrdPtr).FirstUpdateTs == nil) {
-		fmt.Println("rrdPtr.FirstUpdateTs is nil, still waiting on first rrd.Update.")
-		return
-	}
-
-	fmt.Printf("rrdPtr: CurrentAvgCount %d, FirstUpdateTs %d, LastUpdate: %s\n", (*rrdPtr).CurrentAvgCount, (*(*rrdPtr).FirstUpdateTs), (*rrdPtr).LastUpdate.Format(time.Kitchen))
-	fmt.Println("rrdPtr LastUpdateDataPoint:")
+	// DumpTo stdout with rrd.DumpTable
 
-	for e := range (*rrdPtr).LastUpdateDataPoint {
+	DumpTo(os.Stdout, rrdPtr, DumpOptions{})
 
-		if ((*rrdPtr).LastUpdateDataPoint == nil) {
-			fmt.Println("\tnil")
-		} else {
-			fmt.Printf("\t%f\n", (*(*rrdPtr).LastUpdateDataPoint[e]))
-		}
-	}
+}
 
-	fmt.Printf("rrdPtr D (Gauge or Counter VALUES) (%d):\n", len((*rrdPtr).D))
+func RecalculateRate(rrdPtr *Rrd) {
 
-	for e := range (*rrdPtr).D {
+	// recalculate the rate values if the R array exists
+	// with rrd.AlignEpoch a Counter or Derive rate is split into the step of the previous value like an update
+	// the time of a value within its step is not stored, each value is at the time within its step of LastUpdate
 
-		if ((*rrdPtr).D[e] == nil) {
-			fmt.Println("\tInterval", e, "nil")
-			continue
-		}
+	if ((*rrdPtr).HasRates() == true && (*rrdPtr).FirstUpdateTs != nil) {
 
-		var v string
+		var split = (*rrdPtr).Alignment == AlignEpoch && ((*rrdPtr).DataType == Counter || (*rrdPtr).DataType == Derive)
+		var offset = (*rrdPtr).LastUpdate.Sub(step_time(rrdPtr, uint64(max(step_at(rrdPtr, (*rrdPtr).LastUpdate), 0))))
 
-		for n := range (*rrdPtr).D[e] {
+		// the time of the previous step with a value
+		var previous *time.Time
 
-			if ((*rrdPtr).D[e][n] != nil) {
-				v += strconv.FormatFloat((*(*rrdPtr).D[e][n]), 'f', 2, 64) + ", "
-			} else {
-				v += "nil, "
-			}
+		// for each step in order from FirstUpdateTs
+		for ts, values := range (*rrdPtr).Steps() {
 
-		}
+			var e = uint64(step_at(rrdPtr, ts))
 
-		v = strings.TrimSuffix(v, ", ")
+			// reset the rate values
+			clear_rates(rrdPtr, e)
 
-		fmt.Printf("\tInterval %d\t%s\n", e, v)
+			var known = false
 
-	}
+			for l := range values {
 
-	if ((*rrdPtr).R != nil) {
+				if (math.IsNaN(values[l])) {
+					// skip nil D values
+					continue
+				}
 
-		fmt.Printf("rrdPtr R (RATE PER SECOND OF Counter INTERVALS) (%d):\n", len((*rrdPtr).R))
+				calculate_rate(rrdPtr, e, l, values[l])
+				known = true
 
-		for e := range (*rrdPtr).R {
+			}
 
-			if ((*rrdPtr).R[e] == nil) {
-				fmt.Println("\tInterval", e, "nil")
+			if (known == false) {
 				continue
 			}
 
-			var v string
-
-			for n := range (*rrdPtr).R[e] {
-
-				if ((*rrdPtr).R[e][n] != nil) {
-					v += strconv.FormatFloat((*(*rrdPtr).R[e][n]), 'f', 2, 64) + ", "
-				} else {
-					v += "nil, "
-				}
+			var update_ts = ts.Add(offset)
 
+			if (split == true && previous != nil) {
+				split_previous_steps(rrdPtr, (*previous), update_ts, e, values)
 			}
 
-			v = strings.TrimSuffix(v, ", ")
-
-			fmt.Printf("\tInterval %d\t%s\n", e, v)
+			previous = &update_ts
 
 		}
 
@@ -462,208 +569,236 @@ func Dump(rrdPtr *Rrd) {
 
 }
 
-func RecalculateRate(rrdPtr *Rrd) {
+func calculate_rate(rrdPtr *Rrd, step uint64, e int, current_value float64) {
 
-	// recalculate the rate values if the R array exists
+	// set the rate of data point e at step from current_value
+	// Counter and Derive rates are calculated against the closest previous step with a value
+	// and applied to each step since that value
 
-	if ((*rrdPtr).R != nil) {
+	var ds = data_source(rrdPtr, e)
 
-		// for each data point
-		//for e := len((*rrdPtr).R) - 1; e >= 0; e-- {
-		for e := range (*rrdPtr).R {
+	if ((*rrdPtr).DataType == Absolute) {
 
-			// reset the rate values
-			(*rrdPtr).R[e] = nil
+		// the value of an Absolute step is the amount within the step
+		var rate = current_value / (*rrdPtr).Interval.Seconds()
 
-			if ((*rrdPtr).D[e] == nil) {
-				// skip nil D values
-				continue
-			} else if (e == 0) {
-				// skip the first point set, there is nothing to calculate the rate against
-				continue
-			}
+		if (in_bounds(ds, rate) == false) {
+			// the rate is outside of the DataSource range, it is unknown
+			(*rrdPtr).SetRate(step, e, math.NaN())
+			return
+		}
 
-			var steps_between int = 1
-			var skip = false
+		(*rrdPtr).SetRate(step, e, rate)
+		return
 
-			for {
+	}
 
-				if (e - steps_between == -1) {
+	// find the previous step with a value of this data point
+	var steps_between, previous_value, previous_known = previous_value(rrdPtr, step, e)
 
-					// no previous interval has data
-					skip = true
-					break
+	if (previous_known == false) {
+		// no previous step has data, there is nothing to calculate the rate against
+		if (*rrdPtr).Debug { fmt.Printf("No previous (*rrdPtr).Interval has data for data point %d.\n", e) }
+		return
+	}
 
-				} else if ((*rrdPtr).D[e-steps_between] == nil) {
+	if (steps_between > 1) {
+		if (*rrdPtr).Debug { fmt.Printf("Previous %d (*rrdPtr).Intervals are nil.\n", steps_between - 1) }
+	}
 
-					// the previous interval (point set) has no data
-					// go to the next
-					steps_between += 1
+	if (ds.Heartbeat > 0 && (*rrdPtr).Interval * time.Duration(steps_between) > ds.Heartbeat) {
+		// the previous value is older than the heartbeat, the steps between are unknown
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "previous value is older than Heartbeat, the rate is unknown" + colorCodeReset) }
+		return
+	}
 
-					continue
+	// get the value of the (*rrdPtr).Interval
+	var interval_value float64
+	var policy CounterPolicy
 
-				}
+	if ((*rrdPtr).DataType == Derive) {
 
-				// this interval has data
-				break
+		// a Derive can decrease, the rate is negative
+		interval_value = current_value - previous_value
 
-			}
+	} else {
 
-			if (skip == true) {
-				// no previous interval has data
-				continue
-			}
+		policy = counter_policy(rrdPtr, e)
 
-			for l := range (*rrdPtr).D[e - steps_between] {
+		var interval_known bool
+		interval_value, interval_known = counter_interval_value(policy, previous_value, current_value)
 
-				var previous_value_pointer = (*rrdPtr).D[e - steps_between][l]
-				var current_value_pointer = (*rrdPtr).D[e][l]
+		if (interval_known == false) {
+			if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "counter decreased, the rate is unknown" + colorCodeReset) }
+			return
+		}
 
-				if (previous_value_pointer == nil || current_value_pointer == nil) {
-					continue
-				}
+	}
 
-				// get the value of the interval
-				var intervalValue float64 = (*current_value_pointer) - (*previous_value_pointer)
+	if (*rrdPtr).Debug { fmt.Println("calculating the rate for " + strconv.FormatFloat(interval_value, 'f', -1, 64) + " units within", (*rrdPtr).Interval) }
 
-				// check for a counter reset
-				// known by this update value being less than the previous
-				if ((*previous_value_pointer) > (*current_value_pointer)) {
+	// set the rate per second as a float
+	var rate float64 = interval_value / (float64((*rrdPtr).Interval.Seconds()) * float64(steps_between))
 
-					// the counter has reset, need to check if this happened near the 32 or 64 bit limit
+	if (policy.MaxRate > 0 && rate > policy.MaxRate) {
+		// the rate is not plausible, it is unknown
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "rate " + strconv.FormatFloat(rate, 'f', -1, 64) + " exceeds MaxRate, the rate is unknown" + colorCodeReset) }
+		return
+	}
 
-					if ((*previous_value_pointer) < math.MaxUint32 && (*previous_value_pointer) > math.MaxUint32 * .7) {
+	if (in_bounds(ds, rate) == false) {
+		// the rate is outside of the DataSource range, it is unknown
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "rate " + strconv.FormatFloat(rate, 'f', -1, 64) + " is outside of the DataSource range, the rate is unknown" + colorCodeReset) }
+		return
+	}
 
-						// the last update was between 70% and 100% of the 32 bit uint limit
-						// make 32bit adjustments
+	// the rate applies to each step since the previous value
+	for interval := step + 1 - steps_between; interval <= step; interval++ {
 
-						// add the remainder of subtracting the last data point from the 32 bit limit to the (*current_value_pointer)
-						// use it for rate calculation
-						intervalValue = (*current_value_pointer) + math.MaxUint32 - (*previous_value_pointer)
+		if (*rrdPtr).Debug { fmt.Println("inserting data with rate " + strconv.FormatFloat(rate, 'f', -1, 64) + " per second at time slot " + strconv.FormatUint(interval, 10)) }
 
-					} else if ((*previous_value_pointer) < math.MaxUint64 && (*previous_value_pointer) > math.MaxUint64 * .7) {
+		(*rrdPtr).SetRate(interval, e, rate)
 
-						// the rrd struct number types are currently Float64 (with a limit less than Uint64)
-						// this rrd library must be upgraded to use math/big floats anyway
+	}
 
-						// the last update was between 70% and 100% of the 64 bit uint limit
-						// make 64bit adjustments
+}
 
-						// add the remainder of subtracting the last data point from the 64 bit limit to the (*current_value_pointer)
-						// use it for rate calculation
-						intervalValue = (*current_value_pointer) + math.MaxUint64 - (*previous_value_pointer)
+func rrd_now(rrdPtr *Rrd) (time.Time) {
 
-					}
+	if ((*rrdPtr).Clock != nil) {
+		return (*rrdPtr).Clock()
+	}
 
-				}
+	return time.Now()
 
-				// set the rate per second as a float
-				var rate float64 = intervalValue / (float64((*rrdPtr).Interval.Seconds()) * float64(steps_between))
+}
 
-				for {
+func Update(updateDataPoint []*float64, rrdPtr *Rrd) {
 
-					var interval = e + 1 - steps_between
+	// all timing is based on system time at execution of Update
+	// data can be sent from any time zone, even ones you don't know about yet
 
-					if (len((*rrdPtr).R[interval]) <= e) {
-						// add rate
-						(*rrdPtr).R[interval] = append((*rrdPtr).R[interval], &rate)
-					} else {
-						// change existing rate
-						(*rrdPtr).R[interval][e] = &rate
-					}
+	var err = UpdateErr(updateDataPoint, rrdPtr)
 
-					if (steps_between == 1) {
-						// all intervals updated
-						break
-					}
+	if (errors.Is(err, ErrTooFewDataPoints)) {
+		fmt.Println(err)
+		os.Exit(1)
+	} else if (err != nil) {
+		if (*rrdPtr).Debug { fmt.Println(err) }
+	}
 
-					steps_between -= 1
+}
 
-				}
+func UpdateErr(updateDataPoint []*float64, rrdPtr *Rrd) (error) {
 
-			}
+	// Update that returns an error instead of exiting
+
+	return UpdateAt(rrd_now(rrdPtr), updateDataPoint, rrdPtr)
 
+}
+
+func UpdateAt(updateTimeStamp time.Time, updateDataPoint []*float64, rrdPtr *Rrd) (error) {
+
+	// update the Rrd with data of updateTimeStamp instead of the time of execution
+	// updates must be in order, an update older than (*rrdPtr).LastUpdate returns *UpdateTooOldError unless Rrd.ClockPolicy clamps or rebases it
+
+	if (updateDataPoint == nil) {
+		return nil
+	}
+
+	var values = make([]float64, len(updateDataPoint))
+
+	for e := range updateDataPoint {
+
+		if (updateDataPoint[e] == nil) {
+			values[e] = math.NaN()
+		} else {
+			values[e] = (*updateDataPoint[e])
 		}
 
 	}
 
+	return update_at(updateTimeStamp, values, updateDataPoint, rrdPtr)
+
 }
 
-func Update(updateDataPoint []*float64, rrdPtr *Rrd) {
+func UpdateFloat(values []float64, rrdPtr *Rrd) (error) {
 
-	// all timing is based on system time at execution of Update
-	// data can be sent from any time zone, even ones you don't know about yet
+	// Update with float64 values, NaN is an unknown value
 
-	var updateTimeStamp = time.Now()
+	return UpdateFloatAt(rrd_now(rrdPtr), values, rrdPtr)
 
-	if (updateDataPoint == nil) {
-		return
-	}
+}
 
-	if (len(updateDataPoint) < int((*rrdPtr).MinimumDataPoints)) {
+func UpdateFloatAt(updateTimeStamp time.Time, values []float64, rrdPtr *Rrd) (error) {
 
-		fmt.Printf("updateDataPoint must have at least %d values\n", (*rrdPtr).MinimumDataPoints)
-		os.Exit(1)
+	// UpdateAt with float64 values, NaN is an unknown value
+	// with Rrd.Storage == rrd.Flat the update does not allocate for each data point
 
-	} else if (len(updateDataPoint) > int((*rrdPtr).MinimumDataPoints)) {
-		// increase the minimum length when updateDataPoint is longer
-		(*rrdPtr).MinimumDataPoints = uint64(len(updateDataPoint))
+	if (values == nil) {
+		return nil
+	}
 
-		// make all data point arrays at least the length of this update
-		for n := range (*rrdPtr).D {
+	// one allocation for LastUpdateDataPoint
+	var last_values = slices.Clone(values)
+	var last = make([]*float64, len(values))
 
-			if ((*rrdPtr).DataType == Counter) {
+	for e := range last_values {
+		if (math.IsNaN(last_values[e]) == false) {
+			last[e] = &last_values[e]
+		}
+	}
 
-				// Counter
+	return update_at(updateTimeStamp, last_values, last, rrdPtr)
 
-				// R values
+}
 
-				if (len((*rrdPtr).R[n]) == 0) {
-					// skip [] values
-				} else if (len((*rrdPtr).R[n]) != int((*rrdPtr).MinimumDataPoints)) {
-					// add nil values
-					var cur_len = len((*rrdPtr).R[n])
-					var new_value_count = int((*rrdPtr).MinimumDataPoints) - cur_len
-					var l = 0
-					for (l < new_value_count) {
-						// add nil for each new field
-						(*rrdPtr).R[n] = append((*rrdPtr).R[n], nil)
-						l = l + 1
-					}
-				}
+func update_at(updateTimeStamp time.Time, values []float64, updateDataPoint []*float64, rrdPtr *Rrd) (error) {
 
-			}
+	// values and updateDataPoint are the same data, NaN in values is nil in updateDataPoint
 
-			// D values
-			if (len((*rrdPtr).D[n]) == 0) {
-				// skip [] values
-			} else if (len((*rrdPtr).D[n]) != int((*rrdPtr).MinimumDataPoints)) {
-				// add nil values
-				var cur_len = len((*rrdPtr).D[n])
-				var new_value_count = int((*rrdPtr).MinimumDataPoints) - cur_len
-				var l = 0
-				for (l < new_value_count) {
-					// add nil for each new field
-					(*rrdPtr).D[n] = append((*rrdPtr).D[n], nil)
-					l = l + 1
-				}
-			}
+	if (len(values) < int((*rrdPtr).MinimumDataPoints)) {
+		return fmt.Errorf("%w, updateDataPoint must have at least %d values", ErrTooFewDataPoints, (*rrdPtr).MinimumDataPoints)
+	}
 
-		}
+	var location, location_err = time_zone(rrdPtr)
+	if (location_err != nil) {
+		return location_err
+	}
 
+	// the time of the update after a clock regression or forward jump is handled by Rrd.ClockPolicy
+	var clock_ts, clock_err = clock_time(rrdPtr, updateTimeStamp)
+	if (clock_err != nil) {
+		return clock_err
 	}
+	updateTimeStamp = clock_ts
+
+	if ((*rrdPtr).DataType == Gauge) {
+		// Gauge values outside of the DataSource range are unknown
+		values = bound_values(rrdPtr, values)
+	}
+
+	if (len(values) > int((*rrdPtr).MinimumDataPoints)) {
+		// increase the minimum length when updateDataPoint is longer
+		(*rrdPtr).MinimumDataPoints = uint64(len(values))
+
+		// make all data point arrays at least the length of this update
+		extend_data_points(rrdPtr, len(values))
 
-	if ((*rrdPtr).FirstUpdateTs == nil) {
-		if (*rrdPtr).Debug { fmt.Println("FirstUpdateTs is nil") }
 	}
 
 	// rrdPtr - data from previous updates
 	// updateDataPoint - data object for this update
 	// (*rrdPtr).Interval - ideal time between updates
 	// (*rrdPtr).TotalSteps - total steps of data
-	// (*rrdPtr).DataType - rrd.Gauge or rrd.Counter
+	// (*rrdPtr).DataType - rrd.Gauge, rrd.Counter, rrd.Derive or rrd.Absolute
 	// 	rrd.Gauge - values that stay within the range of defined integer types, like the value of raw materials.
 	// 	rrd.Counter - values that count and can exceed the maximum of a defined integer type.
+	// 	rrd.Derive - values that can increase or decrease, a decrease is a negative rate.
+	// 	rrd.Absolute - values that reset on every read, each update is the amount since the previous update.
+	// (*rrdPtr).Consolidation - how Gauge updates within a step are combined
+	// 	Counter and Derive values are always the last value of the step because the rate is calculated from them
+	// 	Absolute values are always the sum of the step
 
 	if (*rrdPtr).Debug {
 
@@ -672,26 +807,54 @@ func Update(updateDataPoint []*float64, rrdPtr *Rrd) {
 		fmt.Println("(*rrdPtr).TotalSteps: " + strconv.FormatUint((*rrdPtr).TotalSteps, 10))
 		if ((*rrdPtr).FirstUpdateTs != nil) {
 			fmt.Println("firstUpdateTs:", (*(*rrdPtr).FirstUpdateTs), updateTimeStamp.Sub((*(*rrdPtr).FirstUpdateTs)), "ago")
+		} else {
+			fmt.Println("FirstUpdateTs is nil")
 		}
 		fmt.Println("updateTimeStamp:", updateTimeStamp)
 		fmt.Println("updateDataPoint:")
 
-		for e := range updateDataPoint {
+		for e := range values {
 
-			if (updateDataPoint[e] == nil) {
+			if (math.IsNaN(values[e])) {
 				fmt.Println("\tnil")
 			} else {
-				fmt.Printf("\t%f\n", (*updateDataPoint[e]))
+				fmt.Printf("\t%f\n", values[e])
 			}
 
 		}
 
 	}
 
+	// if the updateTimeStamp is later than firstUpdateTs + ((*rrdPtr).TotalSteps*2*(*rrdPtr).Interval)
+	// it is a gap that replaces all the data unless the gap is kept
+	var long_gap = (*rrdPtr).FirstUpdateTs != nil && updateTimeStamp.Compare((*(*rrdPtr).FirstUpdateTs).Add(time.Duration((*rrdPtr).TotalSteps * 2) * (*rrdPtr).Interval)) >= 0
+
+	if (long_gap == true && (*rrdPtr).GapPolicy == GapKeep && ((*rrdPtr).D != nil || (*rrdPtr).FD != nil)) {
+		// the previous update is not in effect across the gap
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "### KEEPING THE DATA, THE STEPS OF THE GAP ARE UNKNOWN ###" + colorCodeReset) }
+		keep_gap(rrdPtr, updateTimeStamp, len(values))
+		long_gap = false
+	}
+
+	// the time of the previous update is needed to know if this update is in the same step
+	var previousUpdateTimeStamp = (*rrdPtr).LastUpdate
+
 	// store updateDataPoint array as lastUpdateDataPoint
 	(*rrdPtr).LastUpdateDataPoint = updateDataPoint
 	(*rrdPtr).LastUpdate = updateTimeStamp
 
+	if ((*rrdPtr).FirstUpdateTs != nil) {
+
+		// if there is a long gap or there is no storage
+		// it is a new chart
+		if (long_gap == true || ((*rrdPtr).D == nil && (*rrdPtr).FD == nil)) {
+			// set firstUpdateTs to nil, this will be considered the first update
+			if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "### THIS UPDATE IS NEW ENOUGH TO REPLACE ALL THE DATA ###" + colorCodeReset) }
+			(*rrdPtr).FirstUpdateTs = nil
+		}
+
+	}
+
 	// first need to see if this is the first update or not
 	if ((*rrdPtr).FirstUpdateTs == nil) {
 
@@ -699,376 +862,659 @@ func Update(updateDataPoint []*float64, rrdPtr *Rrd) {
 		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "### INSERTING FIRST UPDATE ###" + colorCodeReset) }
 
 		// create the array of data points
-		(*rrdPtr).D = make([][]*float64, (*rrdPtr).TotalSteps)
-		if ((*rrdPtr).DataType == Counter) {
-			// Counter
-			(*rrdPtr).R = make([][]*float64, (*rrdPtr).TotalSteps)
-		}
+		reset_storage(rrdPtr, len(values))
+
+		// the first step is stored at the start of the ring
+		(*rrdPtr).Head = 0
 
 		// insert the data for each data point
-		for e := range updateDataPoint {
+		for e := range values {
 
-			if (*rrdPtr).Debug {
-				if (updateDataPoint[e] == nil) {
-					fmt.Println("\tnil")
-				} else {
-					fmt.Printf("\t%f\n", (*updateDataPoint[e]))
-				}
-			}
+			(*rrdPtr).SetValue(0, e, values[e])
 
-			(*rrdPtr).D[0] = append((*rrdPtr).D[0], updateDataPoint[e])
+			if ((*rrdPtr).DataType == Absolute && math.IsNaN(values[e]) == false) {
+				// the rate of Absolute does not need a previous value
+				calculate_rate(rrdPtr, 0, e, values[e])
+			}
 
 		}
 
+		(*rrdPtr).CurrentAvgCount = 1
+		// there is no previous update so the values of the first update were not in effect before it
+		(*rrdPtr).CurrentAvgTime = 0
+
 		// set the firstUpdateTs by first allocating space, then assigning the value
-		(*rrdPtr).FirstUpdateTs = &updateTimeStamp
+		var firstUpdateTs = updateTimeStamp
+		if ((*rrdPtr).Alignment == AlignEpoch) {
+			// the first update is in the step that contains it
+			firstUpdateTs = align_step(updateTimeStamp, (*rrdPtr).Interval, location)
+		}
+		(*rrdPtr).FirstUpdateTs = &firstUpdateTs
 
-	} else {
+		return nil
 
-		// if the updateTimeStamp is later than firstUpdateTs + ((*rrdPtr).TotalSteps*(*rrdPtr).Interval)
-		// or .D has a length of 0
-		// it is a new chart
-		if (updateTimeStamp.Compare((*(*rrdPtr).FirstUpdateTs).Add(time.Duration((*rrdPtr).TotalSteps * 2) * (*rrdPtr).Interval)) >= 0 || len((*rrdPtr).D) == 0) {
-			// set firstUpdateTs to nil, this will be considered the first update
-			if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "### THIS UPDATE IS NEW ENOUGH TO REPLACE ALL THE DATA ###" + colorCodeReset) }
-			(*rrdPtr).FirstUpdateTs = &updateTimeStamp
+	}
 
-			// reset all the data
-			if ((*rrdPtr).DataType == Counter) {
-				// counter types need a rate calculation
-				(*rrdPtr).R = nil
-				(*rrdPtr).R = make([][]*float64, (*rrdPtr).TotalSteps)
-			}
+	// this is not the first update
+	if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "### PROCESSING " + data_type_string((*rrdPtr).DataType) + " UPDATE ###" + colorCodeReset) }
 
-			(*rrdPtr).D = nil
-			(*rrdPtr).D = make([][]*float64, (*rrdPtr).TotalSteps)
+	// the step of this update counted from FirstUpdateTs
+	var currentStep = step_at(rrdPtr, updateTimeStamp)
 
-		}
+	if (currentStep >= int64((*rrdPtr).TotalSteps)) {
+
+		// the update is beyond the last step, shift the data set so it is the last step
+		var shift = uint64(currentStep) - (*rrdPtr).TotalSteps + 1
+
+		if (*rrdPtr).Debug { fmt.Println(colorCodeRed + "shifting data set by: " + strconv.FormatUint(shift, 10) + colorCodeReset) }
+
+		shift_steps(rrdPtr, shift)
 
-		// this is not the first update
-		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "### PROCESSING " + data_type_string((*rrdPtr).DataType) + " UPDATE ###" + colorCodeReset) }
+		currentStep = int64((*rrdPtr).TotalSteps) - 1
 
-		// this timestamp
-		if (*rrdPtr).Debug { fmt.Println("updateTimeStamp:", updateTimeStamp) }
+	}
+
+	if (*rrdPtr).Debug { fmt.Println("currentStep: " + strconv.FormatInt(currentStep, 10)) }
+
+	var current_step = uint64(currentStep)
+
+	// now check if this update is in the same step as the previous update or a newer one
+	if (currentStep > step_at(rrdPtr, previousUpdateTimeStamp)) {
+
+		// this update is in a new time slot
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "##### NEW STEP ##### this update is in a new step" + colorCodeReset) }
+
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "inserting data at: " + strconv.FormatInt(currentStep, 10) + colorCodeReset) }
+
+		// remove any data in this step because this is a NEW STEP
+		clear_step(rrdPtr, current_step)
+
+		// handle different (*rrdPtr).DataType
+		// this is normal processing for an update, assuming there was no previous data missing
+		if ((*rrdPtr).DataType == Gauge) {
 
-		// get the time steps for each position, based on firstUpdateTs
-		var timeSteps []time.Time
-		var currentStep uint64 = 0
-		var c uint64 = 0
-		for (c < (*rrdPtr).TotalSteps) {
-			timeSteps = append(timeSteps, (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(c)))
+			// Gauge
 
-			if (updateTimeStamp.Compare((*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(c))) >= 0) {
-				currentStep = c
+			// insert the data for each data point
+			for e := range values {
+				(*rrdPtr).SetValue(current_step, e, values[e])
 			}
 
-			c++
-		}
+			if (time_weighted(rrdPtr) == true) {
+				// the values were in effect from the previous update, in the previous step and the steps between
+				weight_previous_steps(rrdPtr, previousUpdateTimeStamp, updateTimeStamp, current_step, values)
+			}
 
-		// currentTimeSlot will always be now or the newest because the loop iterates (*rrdPtr).TotalSteps times
-		if (*rrdPtr).Debug { fmt.Println("currentStep: " + strconv.FormatUint(currentStep, 10)) }
+			// set the avgCount to 1
+			(*rrdPtr).CurrentAvgCount = 1
+			(*rrdPtr).CurrentAvgTime = updateTimeStamp.Sub(step_time(rrdPtr, current_step))
 
-		// now check if this update is in the current time slot or a newer one
-		if (updateTimeStamp.Compare(timeSteps[currentStep]) >= 0 && currentStep != 0) {
-			// this update is in a new time slot
-			// and it is not the first time slot (multiple updates can happen in the first time slot)
-			if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "##### NEW STEP ##### this update is in a new step" + colorCodeReset) }
+		} else if (is_rate_type((*rrdPtr).DataType) == true) {
 
-			// shift the data set
-			if (currentStep == (*rrdPtr).TotalSteps - 1) {
-				// shift the data set
+			// Counter, Derive or Absolute
 
-				// calculate how much to shift by
-				var shift int64 = 1
-				if (updateTimeStamp.Compare((*(*rrdPtr).FirstUpdateTs).Add(time.Duration((*rrdPtr).TotalSteps) * (*rrdPtr).Interval)) >= 0) {
-					// this update needs to shift by more than 1 time slot
-					var time_diff = updateTimeStamp.Sub((*(*rrdPtr).FirstUpdateTs).Add(time.Duration((*rrdPtr).TotalSteps) * (*rrdPtr).Interval))
+			// for each data point
+			for e := range values {
 
-					if (*rrdPtr).Debug { fmt.Println("time_diff", time_diff) }
+				// add the updateDataPoint to D
+				(*rrdPtr).SetValue(current_step, e, values[e])
 
-					// shift by the number of steps beyond the last
-					shift = (time_diff.Nanoseconds() / (*rrdPtr).Interval.Nanoseconds()) - 1
+				if (math.IsNaN(values[e])) {
+					// there is no way to calculate a rate from a nil value
+					continue
 				}
 
-				if (*rrdPtr).Debug { fmt.Println(colorCodeRed + "shifting data set by: " + strconv.FormatInt(shift, 10) + colorCodeReset) }
+				calculate_rate(rrdPtr, current_step, e, values[e])
 
-				if (shift > 0) {
+			}
 
-					// shift the data set
+			if ((*rrdPtr).Alignment == AlignEpoch) {
+				// the update is not at the start of the step, the change since the previous update is split across the boundary
+				split_previous_steps(rrdPtr, previousUpdateTimeStamp, updateTimeStamp, current_step, values)
+			}
 
-					var temp = make([][]*float64, (*rrdPtr).TotalSteps)
-					for e := range (*rrdPtr).D {
+		} else {
+			if (*rrdPtr).Debug { fmt.Println("unsupported (*rrdPtr).DataType " + data_type_string((*rrdPtr).DataType)) }
+		}
 
-						if (int64(e) >= shift) {
-							// add data points before shift, at their original position - shift
-							temp[e - int(shift)] = (*rrdPtr).D[e]
-						}
+	} else {
 
-					}
+		// this update is in the same step group as the previous
+		if (*rrdPtr).Debug { fmt.Println("##### SAME STEP ##### this update is in the same step as the previous") }
 
-					// copy temp to (*rrdPtr).D
-					copy((*rrdPtr).D, temp)
+		// handle different (*rrdPtr).DataType
+		if ((*rrdPtr).DataType == Gauge) {
 
-					temp = nil
+			// Gauge
 
-					if ((*rrdPtr).DataType == Counter) {
+			// this update needs to be consolidated with the data in this step
 
-						// Counter
+			// need to do this for each data point
+			for e := range values {
 
-						// shift the existing rates
+				if (math.IsNaN(values[e])) {
+					// a nil value shouldn't remove existing nil values of the same step
+					continue
+				}
 
-						var temp = make([][]*float64, (*rrdPtr).TotalSteps)
-						for e := range (*rrdPtr).R {
+				var existing, known = (*rrdPtr).Value(current_step, e)
 
-							if (int64(e) >= shift) {
-								// add data points before shift, at their original position - shift
-								temp[e - int(shift)] = (*rrdPtr).R[e]
-							}
+				if (known == false) {
+					// there is no value to consolidate with
+					(*rrdPtr).SetValue(current_step, e, values[e])
+					continue
+				}
 
-						}
+				// consolidate with a value in the same step
+				if (*rrdPtr).Debug { fmt.Println(consolidation_string((*rrdPtr).Consolidation) + " with a value in the same step") }
 
-						// copy temp to (*rrdPtr).R
-						copy((*rrdPtr).R, temp)
+				var consolidated float64
+				if (time_weighted(rrdPtr) == true) {
+					consolidated = weighted_average(existing, (*rrdPtr).CurrentAvgTime, values[e], updateTimeStamp.Sub(previousUpdateTimeStamp))
+				} else {
+					consolidated = consolidate((*rrdPtr).Consolidation, existing, values[e], (*rrdPtr).CurrentAvgCount)
+				}
 
-						temp = nil
+				if (*rrdPtr).Debug { fmt.Println("updating data point with " + consolidation_string((*rrdPtr).Consolidation) + " " + strconv.FormatFloat(consolidated, 'f', -1, 64)) }
+				(*rrdPtr).SetValue(current_step, e, consolidated)
 
-					}
+			}
+
+			// increment the avg count once for this update
+			(*rrdPtr).CurrentAvgCount++
+			(*rrdPtr).CurrentAvgTime += updateTimeStamp.Sub(previousUpdateTimeStamp)
+
+		} else if ((*rrdPtr).DataType == Counter || (*rrdPtr).DataType == Derive) {
+
+			// Counter or Derive
 
-					// set FirstUpdateTs based on shift
-					*(*rrdPtr).FirstUpdateTs = (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(shift))
+			// set the counter on this step to that of this update
+			for e := range values {
 
+				if (math.IsNaN(values[e])) {
+					// a nil value shouldn't remove existing nil values of the same step
+					continue
 				}
 
+				(*rrdPtr).SetValue(current_step, e, values[e])
 			}
 
-			if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "inserting data at: " + strconv.FormatUint(currentStep, 10) + colorCodeReset) }
+		} else if ((*rrdPtr).DataType == Absolute) {
 
-			// remove any data in this step because this is a NEW STEP
-			(*rrdPtr).D[currentStep] = nil
-			if ((*rrdPtr).DataType == Counter) {
-				// Counter
-				(*rrdPtr).R[currentStep] = nil
-			}
+			// Absolute
 
-			// handle different (*rrdPtr).DataType
-			// this is normal processing for an update, assuming there was no previous data missing
-			if ((*rrdPtr).DataType == Gauge) {
+			// each update is the amount since the previous update, the step is the sum
+			for e := range values {
 
-				// Gauge
+				if (math.IsNaN(values[e])) {
+					// a nil value shouldn't remove existing nil values of the same step
+					continue
+				}
 
-				// insert the data for each data point
-				for e := range updateDataPoint {
-					(*rrdPtr).D[currentStep] = append((*rrdPtr).D[currentStep], updateDataPoint[e])
+				var existing, known = (*rrdPtr).Value(current_step, e)
+
+				if (known == true) {
+					(*rrdPtr).SetValue(current_step, e, existing + values[e])
+				} else {
+					(*rrdPtr).SetValue(current_step, e, values[e])
 				}
 
-				// set the avgCount to 1
-				(*rrdPtr).CurrentAvgCount = 1
+				var sum, _ = (*rrdPtr).Value(current_step, e)
 
-			} else if ((*rrdPtr).DataType == Counter) {
+				calculate_rate(rrdPtr, current_step, e, sum)
 
-				// Counter
+			}
 
-				// for each data point
-				for e := range updateDataPoint {
+		} else {
+			if (*rrdPtr).Debug { fmt.Println("unsupported (*rrdPtr).DataType " + data_type_string((*rrdPtr).DataType)) }
 
-					// add the updateDataPoint to D
-					(*rrdPtr).D[currentStep] = append((*rrdPtr).D[currentStep], updateDataPoint[e])
+		}
+	}
 
-					var steps_between uint64 = 1
+	if (*rrdPtr).Debug {
+		if ((*rrdPtr).Storage == Flat) {
+			fmt.Printf("data: %+v\n", (*rrdPtr).FD)
+		} else {
+			fmt.Printf("data: %+v\n", (*rrdPtr).D)
+		}
+	}
 
-					if ((*rrdPtr).D[currentStep - steps_between] == nil) {
+	return nil
 
-						// the previous (*rrdPtr).Interval has no data
-						// find the one before it that does
+}
 
-						var skip = false
+func time_weighted(rrdPtr *Rrd) (bool) {
 
-						for {
+	// true when Gauge updates of a step are averaged by the time they were in effect
 
-							if (currentStep - steps_between == math.MaxUint64) {
-								// no previous (*rrdPtr).Interval has data
-								skip = true
-								break
-							} else if ((*rrdPtr).D[currentStep - steps_between] == nil) {
-								// this previous (*rrdPtr).Interval does not have data
-								steps_between += 1
-								continue
-							}
+	return (*rrdPtr).DataType == Gauge && (*rrdPtr).Consolidation == Average && (*rrdPtr).Weighting == WeightTime
 
-							// this interval has data
-							break
+}
 
-						}
+func weighted_average(existing float64, existing_time time.Duration, v float64, v_time time.Duration) (float64) {
 
-						if (*rrdPtr).Debug {
-							fmt.Printf("Previous %d (*rrdPtr).Intervals are nil.\n", steps_between)
-						}
+	// return the average of existing in effect for existing_time and v in effect for v_time
 
-						if (skip == true) {
-							// no previous (*rrdPtr).Interval has data
-							continue
-						}
+	if (existing_time + v_time <= 0) {
+		return v
+	}
 
-					} else if (updateDataPoint[e] == nil) {
+	return (existing * existing_time.Seconds() + v * v_time.Seconds()) / (existing_time + v_time).Seconds()
 
-						// there is no way to calculate a rate from a nil value
-						continue
+}
 
-					}
+func weight_previous_steps(rrdPtr *Rrd, previous time.Time, updateTimeStamp time.Time, current_step uint64, values []float64) {
 
-					// calculate the rate because this is a counter
-					// get the value of the (*rrdPtr).Interval
-					var interval_value float64 = (*updateDataPoint[e]) - (*(*rrdPtr).D[currentStep - steps_between][e])
+	// the values of an update in a new step were in effect from the previous update
+	// average them into the rest of the step of the previous update by time and set them in the steps between
+	// CurrentAvgTime is the time of the step of the previous update
 
-					// check for a counter reset
-					// known by this update value being less than the previous
-					if ((*(*rrdPtr).D[currentStep - steps_between][e]) > (*updateDataPoint[e])) {
+	var previous_step = step_at(rrdPtr, previous)
 
-						// the counter has reset, need to check if this happened near the 32 or 64 bit limit
-						if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "counter reset" + colorCodeReset) }
+	for e := range values {
 
-						if ((*(*rrdPtr).D[currentStep - steps_between][e]) < math.MaxUint32 && (*(*rrdPtr).D[currentStep - steps_between][e]) > math.MaxUint32 * .7) {
+		if (math.IsNaN(values[e])) {
+			// the data point is unknown since the previous update
+			continue
+		}
 
-							// the last update was between 70% and 100% of the 32 bit uint limit
-							// make 32bit adjustments
+		var heartbeat = data_source(rrdPtr, e).Heartbeat
+		if (heartbeat > 0 && updateTimeStamp.Sub(previous) > heartbeat) {
+			// the value was not in effect for that long
+			continue
+		}
 
-							// add the remainder of subtracting the last data point from the 32 bit limit to the updateDataPoint
-							// use it for rate calculation
-							interval_value = (*updateDataPoint[e]) + math.MaxUint32 - (*(*rrdPtr).D[currentStep - steps_between][e])
+		if (previous_step >= 0) {
 
-						} else if ((*(*rrdPtr).D[currentStep - steps_between][e]) < math.MaxUint64 && (*(*rrdPtr).D[currentStep - steps_between][e]) > math.MaxUint64 * .7) {
+			// the step of the previous update is still stored
+			var existing, known = (*rrdPtr).Value(uint64(previous_step), e)
 
-							// the rrd struct number types are currently Float64 (with a limit less than Uint64)
-							// this rrd library must be upgraded to use math/big floats anyway
+			if (known == true) {
+				(*rrdPtr).SetValue(uint64(previous_step), e, weighted_average(existing, (*rrdPtr).CurrentAvgTime, values[e], step_time(rrdPtr, uint64(previous_step + 1)).Sub(previous)))
+			} else {
+				(*rrdPtr).SetValue(uint64(previous_step), e, values[e])
+			}
 
-							// the last update was between 70% and 100% of the 64 bit uint limit
-							// make 64bit adjustments
+		}
 
-							// add the remainder of subtracting the last data point from the 64 bit limit to the updateDataPoint
-							// use it for rate calculation
-							interval_value = (*updateDataPoint[e]) + math.MaxUint64 - (*(*rrdPtr).D[currentStep - steps_between][e])
+		for n := max(previous_step + 1, 0); n < int64(current_step); n++ {
+			(*rrdPtr).SetValue(uint64(n), e, values[e])
+		}
 
-						}
+	}
 
-					}
+}
 
-					if (*rrdPtr).Debug { fmt.Println("calculating the rate for " + strconv.FormatFloat(interval_value, 'f', -1, 64) + " units within", (*rrdPtr).Interval) }
+func split_previous_steps(rrdPtr *Rrd, previous time.Time, updateTimeStamp time.Time, current_step uint64, values []float64) {
 
-					// set the rate per second as a float
-					var rate float64 = interval_value / (float64((*rrdPtr).Interval.Seconds()) * float64(steps_between))
+	// split the change of an update in a new step across the steps since the previous update by the time in each step
+	// an Absolute amount is divided by time, a Counter or Derive rate is averaged into the step of the previous update by time
 
-					for {
+	var previous_step = step_at(rrdPtr, previous)
+	var elapsed = updateTimeStamp.Sub(previous)
 
-						var interval uint64 = currentStep + 1 - steps_between
+	if (elapsed <= 0) {
+		return
+	}
 
-						if (*rrdPtr).Debug { fmt.Println("inserting data with rate " + strconv.FormatFloat(rate, 'f', -1, 64) + " per second at time slot " + strconv.FormatUint(interval, 10)) }
+	for e := range values {
 
-						if (len((*rrdPtr).R[interval]) <= e) {
-							// add rate
-							(*rrdPtr).R[interval] = append((*rrdPtr).R[interval], &rate)
-						} else {
-							// change existing rate
-							(*rrdPtr).R[interval][e] = &rate
-						}
+		if (math.IsNaN(values[e])) {
+			continue
+		}
 
-						if (steps_between == 1) {
-							// all intervals updated
-							break
-						}
+		if ((*rrdPtr).DataType == Absolute) {
 
-						steps_between -= 1
+			var ds = data_source(rrdPtr, e)
+			if (ds.Heartbeat > 0 && elapsed > ds.Heartbeat) {
+				// the amount is not divided over a time longer than the heartbeat
+				continue
+			}
 
-					}
+			// the time of each step since the previous update
+			for n := max(previous_step, 0); n <= int64(current_step); n++ {
 
+				var start = step_time(rrdPtr, uint64(n))
+				if (start.Before(previous)) {
+					start = previous
 				}
 
-			} else {
-				if (*rrdPtr).Debug { fmt.Println("unsupported (*rrdPtr).DataType " + data_type_string((*rrdPtr).DataType)) }
+				var end = step_time(rrdPtr, uint64(n + 1))
+				if (end.After(updateTimeStamp)) {
+					end = updateTimeStamp
+				}
+
+				var amount = values[e] * end.Sub(start).Seconds() / elapsed.Seconds()
+
+				if (n == previous_step) {
+					// add to the amount of the step of the previous update
+					var existing, known = (*rrdPtr).Value(uint64(n), e)
+					if (known == true) {
+						amount += existing
+					}
+				}
+
+				(*rrdPtr).SetValue(uint64(n), e, amount)
+				calculate_rate(rrdPtr, uint64(n), e, amount)
+
 			}
 
-		} else if (len((*rrdPtr).D[currentStep]) == len(updateDataPoint)) {
+			continue
 
-			// this update is in the same step group as the previous
-			if (*rrdPtr).Debug { fmt.Println("##### SAME STEP ##### this update is in the same step as the previous") }
+		}
 
-			// handle different (*rrdPtr).DataType
-			if ((*rrdPtr).DataType == Gauge) {
+		if (previous_step < 0) {
+			// the step of the previous update is not stored
+			continue
+		}
 
-				// Gauge
+		var rate, rate_known = (*rrdPtr).Rate(current_step, e)
+		if (rate_known == false) {
+			continue
+		}
 
-				// this update needs to be averaged with the data in this step
+		if _, previous_known := (*rrdPtr).Value(uint64(previous_step), e); previous_known == false {
+			// the rate was not calculated from the previous update
+			continue
+		}
 
-				// need to do this for each data point
-				for e := range updateDataPoint {
+		// the rate of the step of the previous update is known from its start to the previous update
+		var step_start = step_time(rrdPtr, uint64(previous_step))
+		var existing, known = (*rrdPtr).Rate(uint64(previous_step), e)
 
-					if (updateDataPoint[e] == nil) {
-						// a nil value shouldn't remove existing nil values of the same step
-						continue
-					}
+		if (known == true) {
+			(*rrdPtr).SetRate(uint64(previous_step), e, weighted_average(existing, previous.Sub(step_start), rate, step_time(rrdPtr, uint64(previous_step + 1)).Sub(previous)))
+		} else {
+			(*rrdPtr).SetRate(uint64(previous_step), e, rate)
+		}
 
-					var avg float64
+	}
 
-					// average with a value in the same step
-					if (*rrdPtr).Debug { fmt.Println("average with a value in the same step") }
+}
 
-					// multiply the avgCount with the existing value
-					avg = float64((*rrdPtr).CurrentAvgCount) * (*(*rrdPtr).D[currentStep][e])
+func time_zone(rrdPtr *Rrd) (*time.Location, error) {
 
-					// add this updateDataPoint
-					avg += (*updateDataPoint[e])
+	// return the location of Rrd.TimeZone
 
-					// increment the avg count
-					(*rrdPtr).CurrentAvgCount++
+	if ((*rrdPtr).TimeZone == "") {
+		return time.UTC, nil
+	}
 
-					// then divide by the avgCount to get the new average
-					avg = avg/float64((*rrdPtr).CurrentAvgCount)
+	if location, ok := time_zones.Load((*rrdPtr).TimeZone); ok == true {
+		return location.(*time.Location), nil
+	}
 
-					if (*rrdPtr).Debug { fmt.Println("updating data point with avg " + strconv.FormatFloat(avg, 'f', -1, 64)) }
-					(*rrdPtr).D[currentStep][e] = &avg
+	var location, err = time.LoadLocation((*rrdPtr).TimeZone)
+	if (err != nil) {
+		return nil, fmt.Errorf("TimeZone %s is not valid: %w", (*rrdPtr).TimeZone, err)
+	}
 
+	time_zones.Store((*rrdPtr).TimeZone, location)
 
-				}
+	return location, nil
 
-			} else if ((*rrdPtr).DataType == Counter) {
+}
 
-				// Counter
+func align_step(ts time.Time, interval time.Duration, location *time.Location) (time.Time) {
 
-				// set the counter on this step to that of this update
-				for e := range updateDataPoint {
+	// return the start of the step of interval that contains ts, steps start at multiples of interval since the Unix epoch in location
 
-					if (updateDataPoint[e] == nil) {
-						// a nil value shouldn't remove existing nil values of the same step
-						continue
-					}
+	var _, offset = ts.In(location).Zone()
+	var local = time.Duration(ts.UnixNano()) + time.Duration(offset) * time.Second
 
-					(*rrdPtr).D[currentStep][e] = updateDataPoint[e]
-				}
+	var into = local % interval
+	if (into < 0) {
+		into += interval
+	}
 
-			} else {
-				if (*rrdPtr).Debug { fmt.Println("unsupported (*rrdPtr).DataType " + data_type_string((*rrdPtr).DataType)) }
+	return ts.Add(-into)
 
-			}
+}
+
+func step_slot(rrdPtr *Rrd, step uint64) (uint64) {
+
+	// D and R are a ring with the step of FirstUpdateTs at Head
+	// return the index in D and R of step
+
+	return ((*rrdPtr).Head + step) % (*rrdPtr).TotalSteps
+
+}
+
+func step_at(rrdPtr *Rrd, ts time.Time) (int64) {
+
+	// return the step of ts counted from FirstUpdateTs
+	// this is negative when ts is before FirstUpdateTs
+
+	var d = ts.Sub((*(*rrdPtr).FirstUpdateTs))
+	var step = int64(d / (*rrdPtr).Interval)
+
+	if (d < 0 && d % (*rrdPtr).Interval != 0) {
+		// round toward the earlier step
+		step -= 1
+	}
+
+	return step
+
+}
+
+func shift_steps(rrdPtr *Rrd, shift uint64) {
+
+	// move the ring forward by shift steps, the oldest steps are removed
+
+	var clear = shift
+	if (clear > (*rrdPtr).TotalSteps) {
+		clear = (*rrdPtr).TotalSteps
+	}
+
+	for c := uint64(0); c < clear; c++ {
+		clear_step(rrdPtr, c)
+	}
+
+	(*rrdPtr).Head = ((*rrdPtr).Head + shift) % (*rrdPtr).TotalSteps
+
+	// set FirstUpdateTs based on shift
+	*(*rrdPtr).FirstUpdateTs = (*(*rrdPtr).FirstUpdateTs).Add((*rrdPtr).Interval * time.Duration(shift))
+
+}
+
+func keep_gap(rrdPtr *Rrd, updateTimeStamp time.Time, data_points int) {
+
+	// update the Rrd without values at the last Interval after the previous update that is before updateTimeStamp
+	// the data is the same as updates without values at each Interval since the previous update, the steps between are unknown
+
+	var previous = (*rrdPtr).LastUpdate
+
+	var intervals = (updateTimeStamp.Sub(previous) - 1) / (*rrdPtr).Interval
+	if (intervals < 1) {
+		return
+	}
+
+	var gap_ts = previous.Add((*rrdPtr).Interval * intervals)
+
+	var step = step_at(rrdPtr, gap_ts)
+	if (step >= int64((*rrdPtr).TotalSteps)) {
+		shift_steps(rrdPtr, uint64(step) - (*rrdPtr).TotalSteps + 1)
+		step = int64((*rrdPtr).TotalSteps) - 1
+	}
+
+	// the step of gap_ts is after the step of the previous update, it is a new step without values
+	clear_step(rrdPtr, uint64(step))
+
+	if ((*rrdPtr).DataType == Gauge) {
+		(*rrdPtr).CurrentAvgCount = 1
+		(*rrdPtr).CurrentAvgTime = gap_ts.Sub(step_time(rrdPtr, uint64(step)))
+	}
+
+	(*rrdPtr).LastUpdate = gap_ts
+	(*rrdPtr).LastUpdateDataPoint = make([]*float64, data_points)
+
+}
+
+func previous_value(rrdPtr *Rrd, step uint64, e int) (uint64, float64, bool) {
+
+	// return the number of steps between step and the closest previous step with a value for data point e
+	// and that value, false is returned if no previous step has a value
+
+	for steps_between := uint64(1); steps_between <= step; steps_between++ {
+
+		var v, known = (*rrdPtr).Value(step - steps_between, e)
+
+		if (known == true) {
+			return steps_between, v, true
 		}
 
-		timeSteps = nil
+	}
 
-		if (*rrdPtr).Debug { fmt.Printf("data: %+v\n", (*rrdPtr).D) }
+	return 0, math.NaN(), false
+
+}
+
+func is_rate_type(dataType uint8) (bool) {
+
+	// return true if the data type stores a rate in R
+
+	return dataType == Counter || dataType == Derive || dataType == Absolute
+
+}
+
+func counter_interval_value(policy CounterPolicy, previous_value float64, current_value float64) (float64, bool) {
+
+	// return the increase of a counter from previous_value to current_value
+	// false is returned when the policy makes the increase unknown
+
+	var interval_value = current_value - previous_value
+
+	// check for a counter reset
+	// known by this update value being less than the previous
+	if (previous_value > current_value) {
+
+		if (policy.OnDecrease == DecreaseUnknown) {
+
+			// the increase cannot be known
+			return interval_value, false
+
+		} else if (policy.OnDecrease == DecreaseReset) {
+
+			// the counter was reset to zero, the increase is the current value
+			return current_value, true
+
+		} else if (policy.Width == 32) {
+
+			// the counter wrapped at the 32 bit limit
+			return current_value + (math.MaxUint32 + 1) - previous_value, true
+
+		} else if (policy.Width == 64) {
+
+			// the counter wrapped at the 64 bit limit
+			return current_value + (math.MaxUint64 + 1) - previous_value, true
+
+		}
+
+		// the counter has reset, need to check if this happened near the 32 or 64 bit limit
+
+		if (previous_value < math.MaxUint32 && previous_value > math.MaxUint32 * .7) {
+
+			// the last update was between 70% and 100% of the 32 bit uint limit
+			// make 32bit adjustments
+
+			// add the remainder of subtracting the last data point from the 32 bit limit to the current value
+			// use it for rate calculation
+			interval_value = current_value + math.MaxUint32 - previous_value
+
+		} else if (previous_value < math.MaxUint64 && previous_value > math.MaxUint64 * .7) {
+
+			// the rrd struct number types are currently Float64 (with a limit less than Uint64)
+			// this rrd library must be upgraded to use math/big floats anyway
+
+			// the last update was between 70% and 100% of the 64 bit uint limit
+			// make 64bit adjustments
+
+			// add the remainder of subtracting the last data point from the 64 bit limit to the current value
+			// use it for rate calculation
+			interval_value = current_value + math.MaxUint64 - previous_value
 
-		if ((*rrdPtr).Debug) {
-			if (len((*rrdPtr).D[currentStep]) != len(updateDataPoint)) {
-				// something is wrong
-				fmt.Printf("\nDATA LENGTH IS OFF\n\a\a")
-			}
 		}
 
 	}
 
+	return interval_value, true
+
+}
+
+func data_source(rrdPtr *Rrd, e int) (DataSource) {
+
+	// return the DataSource of data point e
+
+	if (e < len((*rrdPtr).DataSources)) {
+		return (*rrdPtr).DataSources[e]
+	}
+
+	return DataSource{}
+
+}
+
+func in_bounds(ds DataSource, v float64) (bool) {
+
+	// return false if v is outside of the Min and Max of the DataSource
+
+	if (ds.Min != nil && v < (*ds.Min)) {
+		return false
+	} else if (ds.Max != nil && v > (*ds.Max)) {
+		return false
+	}
+
+	return true
+
+}
+
+func bound_values(rrdPtr *Rrd, values []float64) ([]float64) {
+
+	// return values with each value outside of the DataSource range unknown
+	// values is copied before the first change
+
+	var copied = false
+
+	for e := range values {
+
+		if (math.IsNaN(values[e]) || in_bounds(data_source(rrdPtr, e), values[e]) == true) {
+			continue
+		}
+
+		if (copied == false) {
+			values = slices.Clone(values)
+			copied = true
+		}
+
+		if (*rrdPtr).Debug { fmt.Println(colorCodeBlue + "value " + strconv.FormatFloat(values[e], 'f', -1, 64) + " is outside of the DataSource range, the value is unknown" + colorCodeReset) }
+
+		values[e] = math.NaN()
+
+	}
+
+	return values
+
+}
+
+func counter_policy(rrdPtr *Rrd, e int) (CounterPolicy) {
+
+	// return the CounterPolicy of data point e
+
+	if (e < len((*rrdPtr).DataSources) && (*rrdPtr).DataSources[e].CounterPolicy != nil) {
+		return (*(*rrdPtr).DataSources[e].CounterPolicy)
+	}
+
+	return (*rrdPtr).CounterPolicy
+
 }
 
 func data_type_string(dataType uint8) (string) {
 
 	if (dataType == Counter) {
 		return "Counter"
+	} else if (dataType == Derive) {
+		return "Derive"
+	} else if (dataType == Absolute) {
+		return "Absolute"
 	}
 
 	return "Gauge"
@@ -1090,9 +1536,23 @@ type TokenQueue struct {
 	LongRrdPointer			*Rrd
 	Cond				*sync.Cond
 	Cancel				context.CancelFunc
+	// returns the time used by the TokenQueue and its Rrd updates, time.Now when nil
+	Clock				func() time.Time
+	// called with the Rrd and the error of each Rrd update that is rejected, like an update that is too old, with the TokenQueue locked
+	OnUpdateError			func(*Rrd, error)
 	sync.RWMutex
 }
 
+func token_queue_now(token_queue_pointer *TokenQueue) (time.Time) {
+
+	if ((*token_queue_pointer).Clock != nil) {
+		return (*token_queue_pointer).Clock()
+	}
+
+	return time.Now()
+
+}
+
 type Token struct {
 	// the size of this token, write size in bytes for example
 	Size				uint64
@@ -1199,10 +1659,21 @@ func SetTokenQueueLimiter(token_queue_pointer **TokenQueue, rate_per_second floa
 				(**token_queue_pointer).Lock()
 
 				// update the RRD to keep track of the rate
-				Update(GetUpdateValues(float64((*token_queue_pointer).Sum)), (*token_queue_pointer).FSRrdPointer)
-				Update(GetUpdateValues(float64((*token_queue_pointer).Sum)), (*token_queue_pointer).RrdPointer)
-				Update(GetUpdateValues(float64((*token_queue_pointer).Sum)), (*token_queue_pointer).MidRrdPointer)
-				Update(GetUpdateValues(float64((*token_queue_pointer).Sum)), (*token_queue_pointer).LongRrdPointer)
+				var now = token_queue_now(*token_queue_pointer)
+				for _, rrdPtr := range []*Rrd{(*token_queue_pointer).FSRrdPointer, (*token_queue_pointer).RrdPointer, (*token_queue_pointer).MidRrdPointer, (*token_queue_pointer).LongRrdPointer} {
+
+					var err = UpdateAt(now, GetUpdateValues(float64((*token_queue_pointer).Sum)), rrdPtr)
+					if (err != nil) {
+
+						if (*rrdPtr).Debug { fmt.Println(err) }
+
+						if ((*token_queue_pointer).OnUpdateError != nil) {
+							(*token_queue_pointer).OnUpdateError(rrdPtr, err)
+						}
+
+					}
+
+				}
 
 				(*(**token_queue_pointer).Cond).Broadcast()
 
@@ -1224,6 +1695,21 @@ func GetUpdateValues(values ...any) ([]*float64) {
 
 	// used to create a []*float64 for rrd.Update that does not access original data but accepts nil values
 
+	var retval, err = GetUpdateValuesErr(values...)
+
+	if (err != nil) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return retval
+
+}
+
+func GetUpdateValuesErr(values ...any) ([]*float64, error) {
+
+	// GetUpdateValues that returns an error instead of exiting
+
 	var retval []*float64
 
 	for _, value := range values {
@@ -1238,13 +1724,12 @@ func GetUpdateValues(values ...any) ([]*float64) {
 			case nil:
 				retval = append(retval, nil)
 			default:
-				fmt.Printf("rrd.GetUpdateValues requires parameters that are float64, *float64 or nil.  value is of type: %T\n", v)
-				os.Exit(1)
+				return nil, fmt.Errorf("%w, rrd.GetUpdateValues requires parameters that are float64, *float64 or nil.  value is of type: %T", ErrUnsupportedValue, v)
 		}
 
 	}
 
-	return retval
+	return retval, nil
 
 }
 
@@ -1256,15 +1741,36 @@ func WaitToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wa
 
 	// only for TokenQueue.Type == rrd.Instant
 
+	var err = WaitTokenErr(token_queue_pointer, size, prio, max_wait)
+
+	if (err != nil) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+}
+
+func WaitTokenErr(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wait *time.Duration) (error) {
+
+	// WaitToken that returns ErrWrongQueueType instead of exiting
+
+	(*token_queue_pointer).RLock()
+	var token_queue_type = (*token_queue_pointer).Type
+	(*token_queue_pointer).RUnlock()
+
+	if (token_queue_type != Instant) {
+		return fmt.Errorf("%w, WaitToken requires TokenQueue.Type == rrd.Instant", ErrWrongQueueType)
+	}
+
 	var token Token
 	token.Size = size
 	token.Prio = prio
-	token.Time = time.Now()
+	token.Time = token_queue_now(token_queue_pointer)
 	token.MaxWait = max_wait
 
 	for {
 
-		if (token.MaxWait != nil && time.Now().Sub(token.Time) >= (*token.MaxWait)) {
+		if (token.MaxWait != nil && token_queue_now(token_queue_pointer).Sub(token.Time) >= (*token.MaxWait)) {
 
 			// this token has a MaxWait that is at least now
 			break
@@ -1291,14 +1797,15 @@ func WaitToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wa
 
 	if ((*token_queue_pointer).Type != Instant) {
 
-		fmt.Println("WaitToken requires TokenQueue.Type == rrd.Instant.")
-		os.Exit(1)
+		// the Type was changed by SetTokenQueueLimiter while waiting
+		(*token_queue_pointer).Unlock()
+		return fmt.Errorf("%w, WaitToken requires TokenQueue.Type == rrd.Instant", ErrWrongQueueType)
 
 	} else if ((*token_queue_pointer).RatePerSecond == 0) {
 
 		// there is no rate set, do not block
 		(*token_queue_pointer).Unlock()
-		return
+		return nil
 
 	}
 
@@ -1308,7 +1815,7 @@ func WaitToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wa
 
 	for {
 
-		if (token.MaxWait != nil && time.Now().Sub(token.Time) >= (*token.MaxWait)) {
+		if (token.MaxWait != nil && token_queue_now(token_queue_pointer).Sub(token.Time) >= (*token.MaxWait)) {
 
 			// this token has a MaxWait that is at least now
 			break
@@ -1372,7 +1879,7 @@ func WaitToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wa
 
 				(*token_queue_pointer).Unlock()
 
-				return
+				return nil
 
 			}
 
@@ -1386,6 +1893,8 @@ func WaitToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wa
 
 	(*(*token_queue_pointer).Cond).Broadcast()
 
+	return nil
+
 }
 
 func QueueToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wait *time.Duration) (*Token) {
@@ -1395,10 +1904,25 @@ func QueueToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_w
 
 	// only for TokenQueue.Type == rrd.Working
 
+	var token_pointer, err = QueueTokenErr(token_queue_pointer, size, prio, max_wait)
+
+	if (err != nil) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	return token_pointer
+
+}
+
+func QueueTokenErr(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_wait *time.Duration) (*Token, error) {
+
+	// QueueToken that returns ErrWrongQueueType instead of exiting
+
 	var token Token
 	token.Size = size
 	token.Prio = prio
-	token.Time = time.Now()
+	token.Time = token_queue_now(token_queue_pointer)
 	token.MaxWait = max_wait
 
 	var only_token = false
@@ -1407,14 +1931,14 @@ func QueueToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_w
 
 	if ((*token_queue_pointer).Type != Working) {
 
-		fmt.Println("QueueToken requires TokenQueue.Type == rrd.Working.")
-		os.Exit(1)
+		(*token_queue_pointer).Unlock()
+		return nil, fmt.Errorf("%w, QueueToken requires TokenQueue.Type == rrd.Working", ErrWrongQueueType)
 
 	} else if ((*token_queue_pointer).RatePerSecond == 0) {
 
 		// there is no rate set, do not block or return a pointer
 		(*token_queue_pointer).Unlock()
-		return nil
+		return nil, nil
 
 	}
 
@@ -1424,7 +1948,7 @@ func QueueToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_w
 
 	for {
 
-		if (token.MaxWait != nil && time.Now().Sub(token.Time) >= (*token.MaxWait)) {
+		if (token.MaxWait != nil && token_queue_now(token_queue_pointer).Sub(token.Time) >= (*token.MaxWait)) {
 
 			// this token has a MaxWait that is at least now
 			break
@@ -1461,7 +1985,7 @@ func QueueToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_w
 
 		for {
 
-			if (token.MaxWait != nil && time.Now().Sub(token.Time) >= (*token.MaxWait)) {
+			if (token.MaxWait != nil && token_queue_now(token_queue_pointer).Sub(token.Time) >= (*token.MaxWait)) {
 
 				// this token has a MaxWait that is at least now
 				break
@@ -1541,7 +2065,7 @@ func QueueToken(token_queue_pointer *TokenQueue, size uint64, prio uint64, max_w
 
 	(*token_queue_pointer).Unlock()
 
-	return &token
+	return &token, nil
 
 }
 
@@ -1607,22 +2131,28 @@ func Bytes_to_size_string(bytes any) (string) {
 			fmt.Printf("%f\n", v)
 	}
 
-	var size_string = ""
+	return size_string(bytesf, 2)
+
+}
+
+func size_string(bytesf float64, precision int) (string) {
+
+	// bytesf with a unit and precision digits after the decimal point, a negative precision is the fewest digits that represent it exactly
+
+	var unit = "B"
 
 	if (bytesf > 1000 * 1000 * 1000 * 1000 * 1000) {
-		size_string = fmt.Sprintf("%.2fPB", bytesf / 1000 / 1000 / 1000 / 1000 / 1000)
+		bytesf, unit = bytesf / 1000 / 1000 / 1000 / 1000 / 1000, "PB"
 	} else if (bytesf > 1000 * 1000 * 1000 * 1000) {
-		size_string = fmt.Sprintf("%.2fTB", bytesf / 1000 / 1000 / 1000 / 1000)
+		bytesf, unit = bytesf / 1000 / 1000 / 1000 / 1000, "TB"
 	} else if (bytesf > 1000 * 1000 * 1000) {
-		size_string = fmt.Sprintf("%.2fGB", bytesf / 1000 / 1000 / 1000)
+		bytesf, unit = bytesf / 1000 / 1000 / 1000, "GB"
 	} else if (bytesf > 1000 * 1000) {
-		size_string = fmt.Sprintf("%.2fMB", bytesf / 1000 / 1000)
+		bytesf, unit = bytesf / 1000 / 1000, "MB"
 	} else if (bytesf > 1000) {
-		size_string = fmt.Sprintf("%.2fKB", bytesf / 1000)
-	} else {
-		size_string = fmt.Sprintf("%.2fB", bytesf)
+		bytesf, unit = bytesf / 1000, "KB"
 	}
 
-	return size_string
+	return strconv.FormatFloat(bytesf, 'f', precision, 64) + unit
 
 }
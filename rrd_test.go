@@ -0,0 +1,616 @@
+package rrd
+
+import (
+	"math"
+	"time"
+	"testing"
+	"encoding/json"
+)
+
+var test_base = time.Unix(1700000000, 0)
+
+// an update of a test at the time since test_base
+type test_update struct {
+	at			time.Duration
+	v			float64
+}
+
+func test_rrd(t *testing.T, storage uint8, total_steps uint64, updates []test_update) (Rrd) {
+
+	// a Gauge Rrd with one data point and steps of a second updated with updates
+
+	var r = Rrd{Interval: time.Second, TotalSteps: total_steps, DataType: Gauge, Storage: storage}
+
+	test_updates(t, &r, updates)
+
+	return r
+
+}
+
+func test_updates(t *testing.T, rrdPtr *Rrd, updates []test_update) {
+
+	t.Helper()
+
+	for _, u := range updates {
+
+		var err = UpdateFloatAt(test_base.Add(u.at), []float64{u.v}, rrdPtr)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+}
+
+func check_ring(t *testing.T, rrdPtr *Rrd, first time.Duration, head uint64, want []float64) {
+
+	// FirstUpdateTs is test_base plus first, the step at FirstUpdateTs is at head and each step has the value of want, NaN is unknown
+
+	t.Helper()
+
+	if ((*(*rrdPtr).FirstUpdateTs).Equal(test_base.Add(first)) == false) {
+		t.Fatalf("FirstUpdateTs is %s after the base, want %s", (*(*rrdPtr).FirstUpdateTs).Sub(test_base), first)
+	}
+
+	if ((*rrdPtr).Head != head) {
+		t.Fatalf("Head is %d, want %d", (*rrdPtr).Head, head)
+	}
+
+	for n := range want {
+
+		var v, known = (*rrdPtr).Value(uint64(n), 0)
+		if (known == math.IsNaN(want[n]) || (known == true && v != want[n])) {
+			t.Errorf("step %d is %v known %t, want %v", n, v, known, want[n])
+		}
+
+		// the step is in the slot Head + n of the ring
+		var slot = (head + uint64(n)) % (*rrdPtr).TotalSteps
+
+		var stored = math.NaN()
+		if ((*rrdPtr).Storage == Flat) {
+			stored = (*rrdPtr).FD[0][slot]
+		} else if ((*rrdPtr).D[slot] != nil && (*rrdPtr).D[slot][0] != nil) {
+			stored = (*(*rrdPtr).D[slot][0])
+		}
+
+		if (math.IsNaN(stored) != math.IsNaN(want[n]) || (math.IsNaN(stored) == false && stored != want[n])) {
+			t.Errorf("slot %d of step %d is %v, want %v", slot, n, stored, want[n])
+		}
+
+	}
+
+}
+
+func TestUpdateRing(t *testing.T) {
+
+	var nan = math.NaN()
+
+	var tests = []struct {
+		name			string
+		updates			[]test_update
+		first			time.Duration
+		head			uint64
+		want			[]float64
+	}{
+		{
+			name: "updates in the same step are averaged",
+			updates: []test_update{{0, 1}, {500 * time.Millisecond, 3}, {900 * time.Millisecond, 5}},
+			first: 0, head: 0, want: []float64{3, nan, nan, nan},
+		},
+		{
+			name: "a new step",
+			updates: []test_update{{0, 1}, {time.Second, 2}, {2500 * time.Millisecond, 3}},
+			first: 0, head: 0, want: []float64{1, 2, 3, nan},
+		},
+		{
+			name: "a step without an update is unknown",
+			updates: []test_update{{0, 1}, {2 * time.Second, 3}},
+			first: 0, head: 0, want: []float64{1, nan, 3, nan},
+		},
+		{
+			name: "a shift of 2 steps keeps the newest steps",
+			updates: []test_update{{0, 1}, {time.Second, 2}, {2 * time.Second, 3}, {3 * time.Second, 4}, {5 * time.Second, 6}},
+			first: 2 * time.Second, head: 2, want: []float64{3, 4, nan, 6},
+		},
+		{
+			name: "a shift of TotalSteps clears every step",
+			updates: []test_update{{0, 1}, {time.Second, 2}, {2 * time.Second, 3}, {3 * time.Second, 4}, {4 * time.Second, 5}, {8 * time.Second, 9}},
+			first: 5 * time.Second, head: 1, want: []float64{nan, nan, nan, 9},
+		},
+		{
+			name: "an update TotalSteps * 2 steps after FirstUpdateTs replaces the data",
+			updates: []test_update{{0, 1}, {time.Second, 2}, {2 * time.Second, 3}, {3 * time.Second, 4}, {4 * time.Second, 5}, {9 * time.Second, 10}},
+			first: 9 * time.Second, head: 0, want: []float64{10, nan, nan, nan},
+		},
+		{
+			name: "Head wraps around TotalSteps",
+			updates: []test_update{{0, 1}, {time.Second, 2}, {2 * time.Second, 3}, {3 * time.Second, 4}, {4 * time.Second, 5}, {5 * time.Second, 6}, {6 * time.Second, 7}, {7 * time.Second, 8}, {8 * time.Second, 9}, {9 * time.Second, 10}, {10 * time.Second, 11}},
+			first: 7 * time.Second, head: 3, want: []float64{8, 9, 10, 11},
+		},
+	}
+
+	for _, storage := range []uint8{Pointer, Flat} {
+
+		for _, test := range tests {
+
+			t.Run(test.name, func(t *testing.T) {
+				var r = test_rrd(t, storage, 4, test.updates)
+				check_ring(t, &r, test.first, test.head, test.want)
+			})
+
+		}
+
+	}
+
+}
+
+func TestUpdateRingLinear(t *testing.T) {
+
+	// a long sequence of updates is the same as a linear array of every step that keeps the newest TotalSteps steps
+	// gaps are shorter than TotalSteps * 2 steps so the data is not replaced
+
+	const total_steps = 7
+
+	// the last value of each step since test_base, the consolidation is rrd.Last
+	var linear = map[int64]float64{}
+	var updates []test_update
+
+	var at time.Duration
+	for n := 0; n < 200; n++ {
+
+		// 0 to 9 updates a step apart, or several updates in a step
+		at += time.Duration((n * 7919) % 10) * 300 * time.Millisecond
+
+		updates = append(updates, test_update{at, float64(n)})
+		linear[int64(at / time.Second)] = float64(n)
+
+	}
+
+	var last_step = int64(at / time.Second)
+	var first_step = max(int64(0), last_step - total_steps + 1)
+
+	var want = make([]float64, total_steps)
+	for n := range want {
+
+		var v, found = linear[first_step + int64(n)]
+		if (found == false) {
+			v = math.NaN()
+		}
+
+		want[n] = v
+
+	}
+
+	for _, storage := range []uint8{Pointer, Flat} {
+
+		var r = Rrd{Interval: time.Second, TotalSteps: total_steps, DataType: Gauge, Storage: storage, Consolidation: Last}
+		test_updates(t, &r, updates)
+
+		check_ring(t, &r, time.Duration(first_step) * time.Second, uint64(first_step) % total_steps, want)
+
+	}
+
+}
+
+func TestUpdateRingJSON(t *testing.T) {
+
+	// a Rrd with Head that is not 0 is the same after a JSON round trip and updates continue the same way
+
+	var updates = []test_update{{0, 1}, {time.Second, 2}, {2 * time.Second, 3}, {3 * time.Second, 4}, {4 * time.Second, 5}, {6 * time.Second, 7}}
+	var later = []test_update{{7 * time.Second, 8}, {9 * time.Second, 10}}
+
+	for _, storage := range []uint8{Pointer, Flat} {
+
+		var r = test_rrd(t, storage, 4, updates)
+
+		if (r.Head == 0) {
+			t.Fatal("Head is 0")
+		}
+
+		var b, err = json.Marshal(&r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		var loaded Rrd
+		err = json.Unmarshal(b, &loaded)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		check_ring(t, &loaded, 3 * time.Second, 3, []float64{4, 5, math.NaN(), 7})
+
+		test_updates(t, &r, later)
+		test_updates(t, &loaded, later)
+
+		check_same_steps(t, &r, &loaded)
+		check_ring(t, &loaded, 6 * time.Second, 2, []float64{7, 8, math.NaN(), 10})
+
+	}
+
+}
+
+func TestUpdateRingDocumentWithoutHead(t *testing.T) {
+
+	// a document stored before Head existed has the steps in order from index 0
+
+	var document = `{
+		"D": [[1], [2], null, null],
+		"R": null,
+		"CurrentAvgCount": 1,
+		"FirstUpdateTs": "2023-11-14T22:13:20Z",
+		"LastUpdateDataPoint": [2],
+		"LastUpdate": "2023-11-14T22:13:21Z",
+		"MinimumDataPoints": 1,
+		"Interval": 1000000000,
+		"TotalSteps": 4,
+		"DataType": 0
+	}`
+
+	var r Rrd
+	var err = json.Unmarshal([]byte(document), &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	check_ring(t, &r, 0, 0, []float64{1, 2, math.NaN(), math.NaN()})
+
+	test_updates(t, &r, []test_update{{2 * time.Second, 3}, {5 * time.Second, 6}})
+
+	check_ring(t, &r, 2 * time.Second, 2, []float64{3, math.NaN(), math.NaN(), 6})
+
+}
+
+func TestGapKeep(t *testing.T) {
+
+	// a gap longer than TotalSteps * 2 steps with GapKeep is the same as updates without values at each Interval since the previous update
+
+	var base = test_base.Add(300 * time.Millisecond)
+
+	for _, data_type := range []uint8{Gauge, Counter} {
+
+		for _, storage := range []uint8{Pointer, Flat} {
+
+			var kept = Rrd{Interval: time.Second, TotalSteps: 8, DataType: data_type, Storage: storage, GapPolicy: GapKeep}
+			var continuous = Rrd{Interval: time.Second, TotalSteps: 8, DataType: data_type, Storage: storage}
+
+			var update = func(rrdPtr *Rrd, ts time.Time, values []*float64) {
+
+				t.Helper()
+
+				var err = UpdateAt(ts, values, rrdPtr)
+				if (err != nil) {
+					t.Fatal(err)
+				}
+
+			}
+
+			for n := 0; n < 5; n++ {
+
+				var in, out = float64(n * 100), float64(n * 10)
+
+				update(&kept, base.Add(time.Duration(n) * time.Second), []*float64{&in, &out})
+				update(&continuous, base.Add(time.Duration(n) * time.Second), []*float64{&in, &out})
+
+			}
+
+			var gap_end = base.Add(4 * time.Second + 20 * time.Second + 700 * time.Millisecond)
+
+			for ts := continuous.LastUpdate.Add(time.Second); ts.Before(gap_end); ts = ts.Add(time.Second) {
+				update(&continuous, ts, []*float64{nil, nil})
+			}
+
+			for n := 0; n < 3; n++ {
+
+				var in, out = float64(5000 + n * 100), float64(500 + n * 10)
+
+				update(&kept, gap_end.Add(time.Duration(n) * time.Second), []*float64{&in, &out})
+				update(&continuous, gap_end.Add(time.Duration(n) * time.Second), []*float64{&in, &out})
+
+				check_same_steps(t, &continuous, &kept)
+
+				if ((*kept.FirstUpdateTs).Equal((*continuous.FirstUpdateTs)) == false || kept.CurrentAvgCount != continuous.CurrentAvgCount || kept.CurrentAvgTime != continuous.CurrentAvgTime) {
+					t.Fatalf("%s FirstUpdateTs %s, CurrentAvgCount %d and CurrentAvgTime %s, want %s, %d and %s", data_type_string(data_type), kept.FirstUpdateTs, kept.CurrentAvgCount, kept.CurrentAvgTime, continuous.FirstUpdateTs, continuous.CurrentAvgCount, continuous.CurrentAvgTime)
+				}
+
+			}
+
+		}
+
+	}
+
+}
+
+func TestWeightTime(t *testing.T) {
+
+	// each value is in effect from the previous update to the update
+	// 	step 0 - 10 at 0s and 20 from 0s to 3s, 40 from 3s to 10s
+	// 	step 1 - 40 from 10s to 12s, 50 from 12s to 20s
+	// 	step 2 - 50
+	// 	step 3 - 50 from 20s to 35s
+	// with a Heartbeat of 5s the values of the updates at 12s and 35s are not in effect before them
+
+	var updates = []test_update{{0, 10}, {3 * time.Second, 20}, {12 * time.Second, 40}, {35 * time.Second, 50}}
+	var nan = math.NaN()
+
+	for _, storage := range []uint8{Pointer, Flat} {
+
+		var r = Rrd{Interval: 10 * time.Second, TotalSteps: 4, DataType: Gauge, Storage: storage, Weighting: WeightTime}
+		test_updates(t, &r, updates)
+
+		check_ring(t, &r, 0, 0, []float64{(20 * 3 + 40 * 7) / 10.0, (40 * 2 + 50 * 8) / 10.0, 50, 50})
+
+		var heartbeat = Rrd{Interval: 10 * time.Second, TotalSteps: 4, DataType: Gauge, Storage: storage, Weighting: WeightTime}
+		heartbeat.DataSources = []DataSource{{Heartbeat: 5 * time.Second}}
+		test_updates(t, &heartbeat, updates)
+
+		check_ring(t, &heartbeat, 0, 0, []float64{20, 40, nan, 50})
+
+	}
+
+}
+
+func TestAlignEpochSplit(t *testing.T) {
+
+	// steps of a day in America/New_York start at midnight of the offset of the first update, EST
+	// daylight saving time starts on 2024-03-10 so the steps after it start at 01:00 EDT
+	// the change since the previous update is split across the step boundary by the time in each step
+
+	var location, err = time.LoadLocation("America/New_York")
+	if (err != nil) {
+		t.Skip(err)
+	}
+
+	var day = 24 * time.Hour
+	var first_step = time.Date(2024, 3, 8, 0, 0, 0, 0, location)
+
+	// updates at noon of each day, 11 hours into the steps after daylight saving time starts
+	var times []time.Time
+	for d := 0; d < 5; d++ {
+		times = append(times, time.Date(2024, 3, 8 + d, 12, 0, 0, 0, location))
+	}
+
+	var amounts = []float64{0, 86400, 2 * 86400, 82800 * 4, 86400}
+
+	for _, data_type := range []uint8{Counter, Absolute} {
+
+		var r = Rrd{Interval: day, TotalSteps: 8, DataType: data_type, Alignment: AlignEpoch, TimeZone: "America/New_York"}
+
+		var counter float64
+		for n := range times {
+
+			counter += amounts[n]
+
+			var v = counter
+			if (data_type == Absolute) {
+				v = amounts[n]
+			}
+
+			err = UpdateFloatAt(times[n], []float64{v}, &r)
+			if (err != nil) {
+				t.Fatal(err)
+			}
+
+		}
+
+		if ((*r.FirstUpdateTs).Equal(first_step) == false) {
+			t.Fatalf("FirstUpdateTs %s, want %s", r.FirstUpdateTs, first_step)
+		}
+
+		var after_dst = time.Date(2024, 3, 11, 1, 0, 0, 0, location)
+		if (step_time(&r, 3).Equal(after_dst) == false) {
+			t.Fatalf("step 3 starts at %s, want %s", step_time(&r, 3), after_dst)
+		}
+
+		for n := range times {
+
+			var start = step_time(&r, uint64(n))
+			var end = step_time(&r, uint64(n + 1))
+
+			// the time of the step before and after the update
+			var before = times[n].Sub(start)
+			var after = end.Sub(times[n])
+
+			var want float64
+
+			if (data_type == Absolute) {
+
+				// the amount since the previous update in this step and the part of the next amount until the end of the step
+				want = amounts[n] * before.Seconds() / times[n].Sub(first_step).Seconds()
+				if (n > 0) {
+					want = amounts[n] * before.Seconds() / times[n].Sub(times[n - 1]).Seconds()
+				}
+
+				if (n + 1 < len(times)) {
+					want += amounts[n + 1] * after.Seconds() / times[n + 1].Sub(times[n]).Seconds()
+				}
+
+				var v, _ = r.Value(uint64(n), 0)
+				if (math.Abs(v - want) > 1e-6) {
+					t.Errorf("the Absolute amount of step %d is %v, want %v", n, v, want)
+				}
+
+				want = want / day.Seconds()
+
+			} else {
+
+				// the rate of the step is the rate to this counter until the update and the rate to the next counter after it
+				var rate = amounts[n] / day.Seconds()
+
+				if (n == 0) {
+					// the first counter has no rate, the next rate is the rate of the step
+					want = amounts[n + 1] / day.Seconds()
+				} else if (n + 1 < len(times)) {
+					want = (rate * before.Seconds() + amounts[n + 1] / day.Seconds() * after.Seconds()) / day.Seconds()
+				} else {
+					want = rate
+				}
+
+			}
+
+			var rate, known = r.Rate(uint64(n), 0)
+			if (known == false || math.Abs(rate - want) > 1e-9) {
+				t.Errorf("the %s rate of step %d is %v known %t, want %v", data_type_string(data_type), n, rate, known, want)
+			}
+
+		}
+
+	}
+
+}
+
+func TestRecalculateRateAlignEpoch(t *testing.T) {
+
+	// the rates of updates at the same time within each step are calculated again with the split into the previous step
+
+	for _, data_type := range []uint8{Counter, Derive, Absolute} {
+
+		var r = Rrd{Interval: time.Hour, TotalSteps: 30, DataType: data_type, Alignment: AlignEpoch, TimeZone: "America/New_York"}
+
+		// across the start of daylight saving time
+		var start = time.Date(2024, 3, 10, 5, 20, 0, 0, time.UTC)
+
+		var counter float64
+		for n := 0; n < 24; n++ {
+
+			if (n == 7) {
+				// a step without an update
+				continue
+			}
+
+			counter += float64(n * n * 60)
+
+			var err = UpdateFloatAt(start.Add(time.Duration(n) * time.Hour), []float64{counter}, &r)
+			if (err != nil) {
+				t.Fatal(err)
+			}
+
+		}
+
+		// a copy without rates
+		var b, err = json.Marshal(&r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		var recalculated Rrd
+		err = json.Unmarshal(b, &recalculated)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		for n := uint64(0); n < recalculated.TotalSteps; n++ {
+			clear_rates(&recalculated, n)
+		}
+
+		RecalculateRate(&recalculated)
+
+		for n := uint64(0); n < r.TotalSteps; n++ {
+
+			var want, want_known = r.Rate(n, 0)
+			var got, known = recalculated.Rate(n, 0)
+
+			if (known != want_known || (known == true && math.Abs(got - want) > 1e-9)) {
+				t.Errorf("the %s rate of step %d is %v known %t after RecalculateRate, want %v known %t", data_type_string(data_type), n, got, known, want, want_known)
+			}
+
+		}
+
+	}
+
+}
+
+func TestCounterPolicy(t *testing.T) {
+
+	// the rate of the step of the last update, NaN is unknown
+
+	var tests = []struct {
+		name			string
+		policy			CounterPolicy
+		sources			[]DataSource
+		previous		float64
+		current			float64
+		want			float64
+	}{
+		{"an increase", CounterPolicy{}, nil, 100, 160, 60},
+		{"a wrap at 32 bits", CounterPolicy{Width: 32}, nil, math.MaxUint32 - 10, 5, 16},
+		{"a wrap at 64 bits", CounterPolicy{Width: 64}, nil, math.MaxUint64 - 4095, 8192, 12288},
+		{"a wrap near the 32 bit limit without a Width", CounterPolicy{}, nil, math.MaxUint32 - 99, 10, 109},
+		{"a reset", CounterPolicy{OnDecrease: DecreaseReset}, nil, 500, 40, 40},
+		{"a decrease that is unknown", CounterPolicy{OnDecrease: DecreaseUnknown}, nil, 500, 40, math.NaN()},
+		{"a rate above MaxRate", CounterPolicy{MaxRate: 1000}, nil, 100, 1000000, math.NaN()},
+		{"a rate at MaxRate", CounterPolicy{MaxRate: 1000}, nil, 100, 1100, 1000},
+		{"the CounterPolicy of the DataSource", CounterPolicy{OnDecrease: DecreaseUnknown}, []DataSource{{CounterPolicy: &CounterPolicy{OnDecrease: DecreaseReset}}}, 500, 40, 40},
+		{"a wrap with a reset policy of the DataSource and a Width of the Rrd", CounterPolicy{Width: 32}, []DataSource{{CounterPolicy: &CounterPolicy{OnDecrease: DecreaseReset}}}, math.MaxUint32 - 10, 5, 5},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			var r = Rrd{Interval: time.Second, TotalSteps: 4, DataType: Counter, CounterPolicy: test.policy, DataSources: test.sources}
+			test_updates(t, &r, []test_update{{0, test.previous}, {time.Second, test.current}})
+
+			var rate, known = r.Rate(1, 0)
+			if (known == math.IsNaN(test.want) || (known == true && rate != test.want)) {
+				t.Fatalf("the rate is %v known %t, want %v", rate, known, test.want)
+			}
+
+		})
+
+	}
+
+}
+
+func TestDataSourceLimits(t *testing.T) {
+
+	// the Heartbeat of a Counter or Derive and the Min and Max of a value or rate
+	// the value or rate of each step, NaN is unknown
+
+	var nan = math.NaN()
+	var ten, fifty, seventy = 10.0, 50.0, 70.0
+
+	var tests = []struct {
+		name			string
+		data_type		uint8
+		source			DataSource
+		updates			[]test_update
+		want			[]float64
+	}{
+		{"a Counter within the Heartbeat", Counter, DataSource{Heartbeat: 3 * time.Second}, []test_update{{0, 100}, {2 * time.Second, 300}}, []float64{nan, 100, 100}},
+		{"a Counter after the Heartbeat expires", Counter, DataSource{Heartbeat: 1500 * time.Millisecond}, []test_update{{0, 100}, {2 * time.Second, 300}}, []float64{nan, nan, nan}},
+		{"a Counter at the Heartbeat", Counter, DataSource{Heartbeat: 2 * time.Second}, []test_update{{0, 100}, {2 * time.Second, 300}}, []float64{nan, 100, 100}},
+		{"a Derive after the Heartbeat expires", Derive, DataSource{Heartbeat: time.Second}, []test_update{{0, 300}, {time.Second, 200}, {3 * time.Second, 100}}, []float64{nan, -100, nan, nan}},
+		{"a Counter rate above Max", Counter, DataSource{Max: &fifty}, []test_update{{0, 100}, {time.Second, 160}, {2 * time.Second, 200}}, []float64{nan, nan, 40}},
+		{"a Counter rate below Min", Counter, DataSource{Min: &seventy}, []test_update{{0, 100}, {time.Second, 160}, {2 * time.Second, 240}}, []float64{nan, nan, 80}},
+		{"an Absolute rate above Max", Absolute, DataSource{Max: &fifty}, []test_update{{0, 60}, {time.Second, 40}}, []float64{nan, 40}},
+		{"a Gauge value below Min", Gauge, DataSource{Min: &ten}, []test_update{{0, 5}, {time.Second, 20}}, []float64{nan, 20}},
+		{"a Gauge value above Max", Gauge, DataSource{Max: &ten}, []test_update{{0, 20}, {time.Second, 7}}, []float64{nan, 7}},
+		{"a Gauge value at Min and Max", Gauge, DataSource{Min: &ten, Max: &ten}, []test_update{{0, 10}}, []float64{10}},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			var r = Rrd{Interval: time.Second, TotalSteps: 4, DataType: test.data_type, DataSources: []DataSource{test.source}}
+			test_updates(t, &r, test.updates)
+
+			for n := range test.want {
+
+				var v, known = r.Value(uint64(n), 0)
+				if (r.HasRates() == true) {
+					v, known = r.Rate(uint64(n), 0)
+				}
+
+				if (known == math.IsNaN(test.want[n]) || (known == true && v != test.want[n])) {
+					t.Errorf("step %d is %v known %t, want %v", n, v, known, test.want[n])
+				}
+
+			}
+
+		})
+
+	}
+
+}
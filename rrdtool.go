@@ -0,0 +1,263 @@
+package rrd
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"time"
+	"bytes"
+	"errors"
+	"strconv"
+	"encoding/binary"
+)
+
+// the rrdtool binary format of x86-64, the structs of rrd_format.h with 8 byte long and 8 byte alignment
+//
+// stat_head	128 bytes, cookie "RRD", version, float_cookie, ds_cnt, rra_cnt, pdp_step
+// ds_def	120 bytes for each data source, ds_nam, dst, minimal heartbeat, min, max
+// rra_def	120 bytes for each RRA, cf_nam, row_cnt, pdp_cnt, xff
+// live_head	16 bytes, last_up and last_up_usec, 8 bytes before version 0003
+// pdp_prep	112 bytes for each data source, last_ds, unknown seconds, value
+// cdp_prep	80 bytes for each data source of each RRA, value, unknown primary data points
+// rra_ptr	8 bytes for each RRA, cur_row
+// rra data	a float64 for each data source of each row of each RRA, cur_row is the newest row
+
+const (
+	rrdtool_float_cookie = 8.642135e130
+	rrdtool_stat_head_size = 128
+	rrdtool_ds_def_size = 120
+	rrdtool_rra_def_size = 120
+	rrdtool_pdp_prep_size = 112
+	rrdtool_cdp_prep_size = 80
+	rrdtool_rra_ptr_size = 8
+)
+
+var (
+	// returned when a file is not a rrdtool file this package can read
+	ErrUnsupportedRrdtool = errors.New("unsupported rrdtool file")
+	// returned when the counts of a rrdtool file are larger than the file
+	ErrInvalidRrdtool = errors.New("invalid rrdtool file")
+)
+
+func ImportRrdtool(r io.Reader) (*Rrd, error) {
+
+	// read a rrdtool .rrd file created on x86-64 into a Rrd
+	// the data is stored like ImportXML
+
+	var doc, step, last_update, err = rrdtool_decode(r)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return xml_import(doc, step, last_update)
+
+}
+
+func ImportRrdtoolSet(r io.Reader) (*RrdSet, error) {
+
+	// read a rrdtool .rrd file created on x86-64 into a RrdSet with an Archive for each RRA
+	// the data is stored like ImportXMLSet
+
+	var doc, step, last_update, err = rrdtool_decode(r)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return xml_import_set(doc, step, last_update)
+
+}
+
+func rrdtool_decode(r io.Reader) (*xml_rrd, time.Duration, time.Time, error) {
+
+	// read the rrdtool file into the same document as the rrdtool dump XML
+
+	var b, err = io.ReadAll(r)
+	if (err != nil) {
+		return nil, 0, time.Time{}, err
+	}
+
+	if (len(b) < rrdtool_stat_head_size || bytes.Equal(b[0:4], []byte("RRD\x00")) == false) {
+		return nil, 0, time.Time{}, fmt.Errorf("%w, the file does not start with RRD", ErrUnsupportedRrdtool)
+	}
+
+	var version = rrdtool_string(b[4:9])
+	var version_number, version_err = strconv.Atoi(version)
+	if (version_err != nil || version_number < 1 || version_number > 4) {
+		return nil, 0, time.Time{}, fmt.Errorf("%w, version %s is not supported, versions 0001 to 0004 are supported", ErrUnsupportedRrdtool, version)
+	}
+
+	if (math.Float64frombits(binary.LittleEndian.Uint64(b[16:])) != rrdtool_float_cookie) {
+
+		if (math.Float64frombits(binary.BigEndian.Uint64(b[16:])) == rrdtool_float_cookie) {
+			return nil, 0, time.Time{}, fmt.Errorf("%w, the file was created on a big endian system, only x86-64 files are supported", ErrUnsupportedRrdtool)
+		} else if (math.Float64frombits(binary.LittleEndian.Uint64(b[12:])) == rrdtool_float_cookie) {
+			return nil, 0, time.Time{}, fmt.Errorf("%w, the file was created on a 32 bit system, only x86-64 files are supported", ErrUnsupportedRrdtool)
+		}
+
+		return nil, 0, time.Time{}, fmt.Errorf("%w, the float cookie is not valid, only x86-64 files are supported", ErrUnsupportedRrdtool)
+
+	}
+
+	var ds_cnt = binary.LittleEndian.Uint64(b[24:])
+	var rra_cnt = binary.LittleEndian.Uint64(b[32:])
+	var pdp_step = binary.LittleEndian.Uint64(b[40:])
+
+	if (ds_cnt == 0 || pdp_step == 0) {
+		return nil, 0, time.Time{}, fmt.Errorf("%w, the file has no data sources or no step", ErrUnsupportedRrdtool)
+	}
+
+	// each count is checked against the file size before it is multiplied, a larger count is not a valid file
+	var size = uint64(len(b))
+
+	if (ds_cnt > size / rrdtool_pdp_prep_size || rra_cnt > size / rrdtool_rra_def_size || (rra_cnt > 0 && ds_cnt > size / rrdtool_cdp_prep_size / rra_cnt)) {
+		return nil, 0, time.Time{}, fmt.Errorf("%w, ds_cnt %d and rra_cnt %d are larger than the file", ErrInvalidRrdtool, ds_cnt, rra_cnt)
+	}
+
+	var live_head_size uint64 = 16
+	if (version_number < 3) {
+		// last_up_usec was added in version 0003
+		live_head_size = 8
+	}
+
+	// the size of the file before the rra data
+	var header_size = rrdtool_stat_head_size + ds_cnt * rrdtool_ds_def_size + rra_cnt * rrdtool_rra_def_size + live_head_size + ds_cnt * rrdtool_pdp_prep_size + rra_cnt * ds_cnt * rrdtool_cdp_prep_size + rra_cnt * rrdtool_rra_ptr_size
+
+	if (uint64(len(b)) < header_size) {
+		return nil, 0, time.Time{}, fmt.Errorf("%w, the file is shorter than the header", ErrUnsupportedRrdtool)
+	}
+
+	var doc xml_rrd
+	doc.Version = version
+	doc.Step = strconv.FormatUint(pdp_step, 10)
+	doc.Ds = make([]xml_ds, ds_cnt)
+	doc.Rra = make([]xml_rra, rra_cnt)
+
+	var offset uint64 = rrdtool_stat_head_size
+
+	for e := range doc.Ds {
+
+		var d = b[offset:]
+		doc.Ds[e].Name = rrdtool_string(d[0:20])
+		doc.Ds[e].Type = rrdtool_string(d[20:40])
+		doc.Ds[e].MinimalHeartbeat = strconv.FormatUint(binary.LittleEndian.Uint64(d[40:]), 10)
+		doc.Ds[e].Min = rrdtool_float(d[48:])
+		doc.Ds[e].Max = rrdtool_float(d[56:])
+
+		offset += rrdtool_ds_def_size
+
+	}
+
+	var row_cnt = make([]uint64, rra_cnt)
+	var data_size uint64
+
+	for l := range doc.Rra {
+
+		var d = b[offset:]
+		doc.Rra[l].Cf = rrdtool_string(d[0:20])
+		row_cnt[l] = binary.LittleEndian.Uint64(d[24:])
+		doc.Rra[l].PdpPerRow = strconv.FormatUint(binary.LittleEndian.Uint64(d[32:]), 10)
+		doc.Rra[l].Xff = rrdtool_float(d[40:])
+
+		if (row_cnt[l] > (size - data_size) / (ds_cnt * 8)) {
+			return nil, 0, time.Time{}, fmt.Errorf("%w, row_cnt %d of RRA %d is larger than the file", ErrInvalidRrdtool, row_cnt[l], l)
+		}
+
+		data_size += row_cnt[l] * ds_cnt * 8
+		offset += rrdtool_rra_def_size
+
+	}
+
+	if (uint64(len(b)) < header_size + data_size) {
+		return nil, 0, time.Time{}, fmt.Errorf("%w, the file is shorter than the rra data", ErrUnsupportedRrdtool)
+	}
+
+	// live_head
+	var last_update = time.Unix(int64(binary.LittleEndian.Uint64(b[offset:])), 0)
+	if (live_head_size == 16) {
+		last_update = time.Unix(last_update.Unix(), int64(binary.LittleEndian.Uint64(b[offset + 8:])) * 1000)
+	}
+	doc.LastUpdate = strconv.FormatInt(last_update.Unix(), 10)
+	offset += live_head_size
+
+	for e := range doc.Ds {
+
+		var d = b[offset:]
+		doc.Ds[e].LastDs = rrdtool_string(d[0:30])
+		doc.Ds[e].UnknownSec = strconv.FormatUint(binary.LittleEndian.Uint64(d[32:]), 10)
+		doc.Ds[e].Value = rrdtool_float(d[40:])
+
+		offset += rrdtool_pdp_prep_size
+
+	}
+
+	for l := range doc.Rra {
+
+		doc.Rra[l].CdpPrep = make([]xml_cdp, ds_cnt)
+
+		for e := range doc.Rra[l].CdpPrep {
+
+			var d = b[offset:]
+			doc.Rra[l].CdpPrep[e].Value = rrdtool_float(d[0:])
+			doc.Rra[l].CdpPrep[e].UnknownDatapoints = strconv.FormatUint(binary.LittleEndian.Uint64(d[8:]), 10)
+
+			offset += rrdtool_cdp_prep_size
+
+		}
+
+	}
+
+	var cur_row = make([]uint64, rra_cnt)
+	for l := range cur_row {
+		cur_row[l] = binary.LittleEndian.Uint64(b[offset:])
+		offset += rrdtool_rra_ptr_size
+	}
+
+	for l := range doc.Rra {
+
+		if (row_cnt[l] > 0 && cur_row[l] >= row_cnt[l]) {
+			return nil, 0, time.Time{}, fmt.Errorf("%w, cur_row %d of RRA %d is not valid", ErrUnsupportedRrdtool, cur_row[l], l)
+		}
+
+		doc.Rra[l].Rows = make([]xml_row, row_cnt[l])
+
+		for n := range doc.Rra[l].Rows {
+
+			// the oldest row is after cur_row
+			var row = (cur_row[l] + 1 + uint64(n)) % row_cnt[l]
+			var d = b[offset + row * ds_cnt * 8:]
+
+			doc.Rra[l].Rows[n].V = make([]string, ds_cnt)
+			for e := range doc.Rra[l].Rows[n].V {
+				doc.Rra[l].Rows[n].V[e] = rrdtool_float(d[e * 8:])
+			}
+
+		}
+
+		offset += row_cnt[l] * ds_cnt * 8
+
+	}
+
+	return &doc, time.Duration(pdp_step) * time.Second, last_update, nil
+
+}
+
+func rrdtool_string(b []byte) (string) {
+
+	// return the C string in b
+
+	var end = bytes.IndexByte(b, 0)
+	if (end == -1) {
+		end = len(b)
+	}
+
+	return string(b[:end])
+
+}
+
+func rrdtool_float(b []byte) (string) {
+
+	// return the float64 in b as a rrdtool dump value
+
+	return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+
+}
@@ -0,0 +1,136 @@
+package rrd
+
+import (
+	"math"
+	"time"
+	"bytes"
+	"errors"
+	"testing"
+	"encoding/binary"
+)
+
+func rrdtool_test_file(rows []float64, cur_row uint64, last_update int64, last_ds string) ([]byte) {
+
+	// a version 0003 GAUGE file with one data source and one AVERAGE RRA of rows with a step of 60 seconds
+
+	var b = make([]byte, rrdtool_stat_head_size + rrdtool_ds_def_size + rrdtool_rra_def_size + 16 + rrdtool_pdp_prep_size + rrdtool_cdp_prep_size + rrdtool_rra_ptr_size + len(rows) * 8)
+
+	copy(b[0:], "RRD\x00")
+	copy(b[4:], "0003\x00")
+	binary.LittleEndian.PutUint64(b[16:], math.Float64bits(rrdtool_float_cookie))
+	binary.LittleEndian.PutUint64(b[24:], 1)
+	binary.LittleEndian.PutUint64(b[32:], 1)
+	binary.LittleEndian.PutUint64(b[40:], 60)
+
+	var offset = rrdtool_stat_head_size
+
+	// ds_def
+	copy(b[offset:], "in")
+	copy(b[offset + 20:], "GAUGE")
+	binary.LittleEndian.PutUint64(b[offset + 40:], 120)
+	binary.LittleEndian.PutUint64(b[offset + 48:], math.Float64bits(math.NaN()))
+	binary.LittleEndian.PutUint64(b[offset + 56:], math.Float64bits(math.NaN()))
+	offset += rrdtool_ds_def_size
+
+	// rra_def
+	copy(b[offset:], "AVERAGE")
+	binary.LittleEndian.PutUint64(b[offset + 24:], uint64(len(rows)))
+	binary.LittleEndian.PutUint64(b[offset + 32:], 1)
+	binary.LittleEndian.PutUint64(b[offset + 40:], math.Float64bits(0.5))
+	offset += rrdtool_rra_def_size
+
+	// live_head
+	binary.LittleEndian.PutUint64(b[offset:], uint64(last_update))
+	offset += 16
+
+	// pdp_prep, the PDP has no known seconds
+	copy(b[offset:], last_ds)
+	binary.LittleEndian.PutUint64(b[offset + 40:], math.Float64bits(0))
+	offset += rrdtool_pdp_prep_size
+
+	// cdp_prep
+	binary.LittleEndian.PutUint64(b[offset:], math.Float64bits(math.NaN()))
+	offset += rrdtool_cdp_prep_size
+
+	// rra_ptr
+	binary.LittleEndian.PutUint64(b[offset:], cur_row)
+	offset += rrdtool_rra_ptr_size
+
+	// the rows in the order of the file, the newest is at cur_row
+	for n := range rows {
+		binary.LittleEndian.PutUint64(b[offset + n * 8:], math.Float64bits(rows[n]))
+	}
+
+	return b
+
+}
+
+func TestImportRrdtool(t *testing.T) {
+
+	// the newest row is at cur_row 1, the oldest row is after it
+
+	var last_update = int64(1700000040)
+	var b = rrdtool_test_file([]float64{3, 4, 1, 2}, 1, last_update, "5")
+
+	var r, err = ImportRrdtool(bytes.NewReader(b))
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	if (r.Interval != time.Minute || r.TotalSteps != 5 || r.LastUpdate.Unix() != last_update) {
+		t.Fatalf("Interval %s, TotalSteps %d, LastUpdate %s", r.Interval, r.TotalSteps, r.LastUpdate)
+	}
+
+	if (r.Names()[0] != "in") {
+		t.Fatalf("the data source name is %q", r.Names()[0])
+	}
+
+	// the rows then the PDP with last_ds
+	var want = []float64{1, 2, 3, 4, 5}
+	var n = 0
+
+	for ts, values := range r.Steps() {
+
+		if (values[0] != want[n]) {
+			t.Errorf("the value at %s is %f, want %f", ts, values[0], want[n])
+		}
+
+		n++
+
+	}
+
+	if (n != len(want)) {
+		t.Fatalf("%d steps, want %d", n, len(want))
+	}
+
+}
+
+func TestImportRrdtoolInvalidCounts(t *testing.T) {
+
+	// counts that wrap when they are multiplied return an error without a panic
+
+	var counts = [][3]uint64{
+		{1 << 62, 1, 0},
+		{1, 1 << 61, 0},
+		{1 << 32, 1 << 32, 0},
+		{1, 1, 1 << 61},
+		{1, 1, math.MaxUint64 / 8 + 1},
+	}
+
+	for _, c := range counts {
+
+		var b = rrdtool_test_file([]float64{1, 2}, 1, 1700000040, "3")
+		binary.LittleEndian.PutUint64(b[24:], c[0])
+		binary.LittleEndian.PutUint64(b[32:], c[1])
+		if (c[2] > 0) {
+			binary.LittleEndian.PutUint64(b[rrdtool_stat_head_size + rrdtool_ds_def_size + 24:], c[2])
+		}
+
+		var _, err = ImportRrdtool(bytes.NewReader(b))
+		if (errors.Is(err, ErrInvalidRrdtool) == false) {
+			t.Errorf("ds_cnt %d, rra_cnt %d and row_cnt %d returned %v, want ErrInvalidRrdtool", c[0], c[1], c[2], err)
+		}
+
+	}
+
+}
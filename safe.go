@@ -0,0 +1,334 @@
+package rrd
+
+import (
+	"io"
+	"cmp"
+	"time"
+	"sync"
+	"slices"
+	"unsafe"
+	"encoding/json"
+	"sync/atomic"
+)
+
+// a SafeRrd is a Rrd with a lock, each method takes the read or write lock the Rrd requires
+// updates take the write lock, Avg, Fetch, Dump and JSON serialization take the read lock
+// BSON, xyzdb and other encoders must encode the Rrd in Read, encoding the SafeRrd itself is not locked
+type SafeRrd struct {
+	rrd			Rrd
+	mutex			sync.RWMutex
+	read_locks		atomic.Uint64
+	write_locks		atomic.Uint64
+	read_contended		atomic.Uint64
+	write_contended		atomic.Uint64
+	read_wait		atomic.Int64
+	write_wait		atomic.Int64
+	max_wait		atomic.Int64
+}
+
+// the lock contention of a SafeRrd
+type LockStats struct {
+	// the number of times the read lock and the write lock were taken
+	ReadLocks		uint64
+	WriteLocks		uint64
+	// the number of times the lock was held by another goroutine when it was taken
+	ReadContended		uint64
+	WriteContended		uint64
+	// the total time waited for the read lock and the write lock
+	ReadWait		time.Duration
+	WriteWait		time.Duration
+	// the longest time waited for either lock
+	MaxWait			time.Duration
+}
+
+func NewSafeRrd(rrdPtr *Rrd) (*SafeRrd) {
+
+	// create a SafeRrd with the Rrd, the SafeRrd shares the data of rrdPtr so rrdPtr must not be used after
+
+	var s SafeRrd
+	s.rrd = (*rrdPtr)
+
+	return &s
+
+}
+
+func (sPtr *SafeRrd) lock() {
+
+	// take the write lock and count the wait when the lock is held
+
+	if ((*sPtr).mutex.TryLock() == false) {
+
+		var start = time.Now()
+		(*sPtr).mutex.Lock()
+
+		(*sPtr).write_contended.Add(1)
+		(*sPtr).waited(&(*sPtr).write_wait, time.Since(start))
+
+	}
+
+	(*sPtr).write_locks.Add(1)
+
+}
+
+func (sPtr *SafeRrd) rlock() {
+
+	// take the read lock and count the wait when the write lock is held
+
+	if ((*sPtr).mutex.TryRLock() == false) {
+
+		var start = time.Now()
+		(*sPtr).mutex.RLock()
+
+		(*sPtr).read_contended.Add(1)
+		(*sPtr).waited(&(*sPtr).read_wait, time.Since(start))
+
+	}
+
+	(*sPtr).read_locks.Add(1)
+
+}
+
+func (sPtr *SafeRrd) waited(total *atomic.Int64, wait time.Duration) {
+
+	(*total).Add(int64(wait))
+
+	for {
+
+		var max_wait = (*sPtr).max_wait.Load()
+		if (int64(wait) <= max_wait || (*sPtr).max_wait.CompareAndSwap(max_wait, int64(wait)) == true) {
+			return
+		}
+
+	}
+
+}
+
+func (sPtr *SafeRrd) Stats() (LockStats) {
+
+	// return the lock contention since NewSafeRrd
+
+	return LockStats{
+		ReadLocks: (*sPtr).read_locks.Load(),
+		WriteLocks: (*sPtr).write_locks.Load(),
+		ReadContended: (*sPtr).read_contended.Load(),
+		WriteContended: (*sPtr).write_contended.Load(),
+		ReadWait: time.Duration((*sPtr).read_wait.Load()),
+		WriteWait: time.Duration((*sPtr).write_wait.Load()),
+		MaxWait: time.Duration((*sPtr).max_wait.Load()),
+	}
+
+}
+
+func (sPtr *SafeRrd) Read(fn func(*Rrd)) {
+
+	// call fn with the Rrd in the read lock
+	// the Rrd must not be changed or kept after fn returns
+
+	(*sPtr).rlock()
+	defer (*sPtr).mutex.RUnlock()
+
+	fn(&(*sPtr).rrd)
+
+}
+
+func (sPtr *SafeRrd) Write(fn func(*Rrd) (error)) (error) {
+
+	// call fn with the Rrd in the write lock
+	// the Rrd must not be kept after fn returns
+
+	(*sPtr).lock()
+	defer (*sPtr).mutex.Unlock()
+
+	return fn(&(*sPtr).rrd)
+
+}
+
+func (sPtr *SafeRrd) Update(updateDataPoint []*float64) (error) {
+
+	// rrd.UpdateErr in the write lock
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateErr(updateDataPoint, rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) UpdateAt(updateTimeStamp time.Time, updateDataPoint []*float64) (error) {
+
+	// rrd.UpdateAt in the write lock
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateAt(updateTimeStamp, updateDataPoint, rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) UpdateFloat(values []float64) (error) {
+
+	// rrd.UpdateFloat in the write lock
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateFloat(values, rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) UpdateFloatAt(updateTimeStamp time.Time, values []float64) (error) {
+
+	// rrd.UpdateFloatAt in the write lock
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateFloatAt(updateTimeStamp, values, rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) UpdateMap(values map[string]float64) (error) {
+
+	// rrd.UpdateMap in the write lock
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateMap(values, rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) UpdateMapAt(updateTimeStamp time.Time, values map[string]float64) (error) {
+
+	// rrd.UpdateMapAt in the write lock
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		return UpdateMapAt(updateTimeStamp, values, rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) Avg(index int, others ...*SafeRrd) (float64) {
+
+	// rrd.Avg of the SafeRrd and others in the read lock of each
+
+	var avg float64
+
+	read_all(append([]*SafeRrd{sPtr}, others...), func(rrdPtrs []*Rrd) {
+		avg = Avg(index, rrdPtrs...)
+	})
+
+	return avg
+
+}
+
+func (sPtr *SafeRrd) AvgByName(name string, others ...*SafeRrd) (float64) {
+
+	// rrd.AvgByName of the SafeRrd and others in the read lock of each
+
+	var avg float64
+
+	read_all(append([]*SafeRrd{sPtr}, others...), func(rrdPtrs []*Rrd) {
+		avg = AvgByName(name, rrdPtrs...)
+	})
+
+	return avg
+
+}
+
+func read_all(safePtrs []*SafeRrd, fn func([]*Rrd)) {
+
+	// call fn with the Rrd of each SafeRrd in the read lock of all of them
+	// the locks are taken in the order of the addresses, a write waiting for one lock can not block goroutines that take the same locks in another order
+	// a SafeRrd that is given more than once is locked once
+
+	slices.SortFunc(safePtrs, func(a *SafeRrd, b *SafeRrd) (int) {
+		return cmp.Compare(uintptr(unsafe.Pointer(a)), uintptr(unsafe.Pointer(b)))
+	})
+	safePtrs = slices.Compact(safePtrs)
+
+	var rrdPtrs = make([]*Rrd, len(safePtrs))
+
+	for l := range safePtrs {
+
+		(*safePtrs[l]).rlock()
+		defer (*safePtrs[l]).mutex.RUnlock()
+
+		rrdPtrs[l] = &(*safePtrs[l]).rrd
+
+	}
+
+	fn(rrdPtrs)
+
+}
+
+func (sPtr *SafeRrd) Fetch(start time.Time, end time.Time, opts FetchOptions) (*FetchResult, error) {
+
+	// Rrd.Fetch in the read lock, the FetchResult does not share data with the Rrd
+
+	var result *FetchResult
+	var err error
+
+	(*sPtr).Read(func(rrdPtr *Rrd) {
+		result, err = (*rrdPtr).Fetch(start, end, opts)
+	})
+
+	return result, err
+
+}
+
+func (sPtr *SafeRrd) Dump() {
+
+	// rrd.Dump in the read lock
+
+	(*sPtr).Read(func(rrdPtr *Rrd) {
+		Dump(rrdPtr)
+	})
+
+}
+
+func (sPtr *SafeRrd) DumpTo(w io.Writer, opts DumpOptions) (error) {
+
+	// rrd.DumpTo in the read lock
+
+	var err error
+
+	(*sPtr).Read(func(rrdPtr *Rrd) {
+		err = DumpTo(w, rrdPtr, opts)
+	})
+
+	return err
+
+}
+
+func (sPtr *SafeRrd) MarshalJSON() ([]byte, error) {
+
+	// the JSON of the Rrd in the read lock
+
+	var b []byte
+	var err error
+
+	(*sPtr).Read(func(rrdPtr *Rrd) {
+		b, err = json.Marshal(rrdPtr)
+	})
+
+	return b, err
+
+}
+
+func (sPtr *SafeRrd) UnmarshalJSON(b []byte) (error) {
+
+	// replace the Rrd with the JSON of a Rrd in the write lock
+	// Debug, Clock and OnClockJump are kept
+
+	var r Rrd
+
+	var err = json.Unmarshal(b, &r)
+	if (err != nil) {
+		return err
+	}
+
+	return (*sPtr).Write(func(rrdPtr *Rrd) (error) {
+		r.Debug = (*rrdPtr).Debug
+		r.Clock = (*rrdPtr).Clock
+		r.OnClockJump = (*rrdPtr).OnClockJump
+		(*rrdPtr) = r
+		return nil
+	})
+
+}
@@ -0,0 +1,121 @@
+package rrd
+
+import (
+	"sync"
+	"time"
+	"testing"
+	"encoding/json"
+)
+
+func TestSafeRrdConcurrent(t *testing.T) {
+
+	// updates, Stats, JSON and the Avg of several SafeRrd run in goroutines, run with -race
+
+	var short = NewSafeRrd(&Rrd{Interval: time.Second, TotalSteps: 10, DataType: Counter})
+	var long = NewSafeRrd(&Rrd{Interval: time.Second * 10, TotalSteps: 10, DataType: Counter})
+
+	var base = time.Unix(1700000000, 0)
+
+	var done = make(chan struct{})
+	var wg sync.WaitGroup
+	var updates sync.WaitGroup
+
+	var update = func(safePtr *SafeRrd) {
+
+		defer updates.Done()
+
+		for n := 0; n < 1000; n++ {
+
+			var err = (*safePtr).UpdateFloatAt(base.Add(time.Duration(n) * 100 * time.Millisecond), []float64{float64(n * 10)})
+			if (err != nil) {
+				t.Error(err)
+				return
+			}
+
+		}
+
+	}
+
+	var read = func(fn func()) {
+
+		defer wg.Done()
+
+		for {
+
+			fn()
+
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+		}
+
+	}
+
+	updates.Add(2)
+	wg.Add(4)
+
+	go update(short)
+	go update(long)
+
+	go read(func() {
+		short.Stats()
+	})
+
+	go read(func() {
+
+		var _, err = json.Marshal(short)
+		if (err != nil) {
+			t.Error(err)
+		}
+
+	})
+
+	go read(func() {
+		short.Avg(0, long)
+	})
+
+	go read(func() {
+		long.Avg(0, short, short)
+	})
+
+	// the readers run until the updates end
+	updates.Wait()
+	close(done)
+	wg.Wait()
+
+	var stats = short.Stats()
+	if (stats.WriteLocks != 1000 || stats.ReadLocks == 0) {
+		t.Fatalf("WriteLocks %d and ReadLocks %d, want 1000 and more than 0", stats.WriteLocks, stats.ReadLocks)
+	}
+
+	var avg = short.Avg(0, long)
+
+	short.Read(func(shortPtr *Rrd) {
+		long.Read(func(longPtr *Rrd) {
+			if (avg != Avg(0, shortPtr, longPtr) || avg == 0) {
+				t.Fatalf("Avg %v, want %v", avg, Avg(0, shortPtr, longPtr))
+			}
+		})
+	})
+
+	var b, err = json.Marshal(short)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	var loaded SafeRrd
+	err = json.Unmarshal(b, &loaded)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	short.Read(func(rrdPtr *Rrd) {
+		loaded.Read(func(loadedPtr *Rrd) {
+			check_same_steps(t, rrdPtr, loadedPtr)
+		})
+	})
+
+}
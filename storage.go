@@ -0,0 +1,550 @@
+package rrd
+
+import (
+	"math"
+	"strconv"
+	"encoding/json"
+)
+
+// the values of one data source for each step, NaN is an unknown value
+// NaN is stored as null in JSON
+type Series []float64
+
+func (s Series) MarshalJSON() ([]byte, error) {
+
+	var b = make([]byte, 0, len(s) * 8 + 2)
+
+	b = append(b, '[')
+
+	for l := range s {
+
+		if (l > 0) {
+			b = append(b, ',')
+		}
+
+		if (math.IsNaN(s[l]) || math.IsInf(s[l], 0)) {
+			b = append(b, "null"...)
+		} else {
+			b = strconv.AppendFloat(b, s[l], 'g', -1, 64)
+		}
+
+	}
+
+	b = append(b, ']')
+
+	return b, nil
+
+}
+
+func (s *Series) UnmarshalJSON(b []byte) (error) {
+
+	var values []*float64
+
+	var err = json.Unmarshal(b, &values)
+	if (err != nil) {
+		return err
+	}
+
+	if (values == nil) {
+		*s = nil
+		return nil
+	}
+
+	*s = make(Series, len(values))
+
+	for l := range values {
+
+		if (values[l] == nil) {
+			(*s)[l] = math.NaN()
+		} else {
+			(*s)[l] = (*values[l])
+		}
+
+	}
+
+	return nil
+
+}
+
+func new_series(length uint64) (Series) {
+
+	// return a Series of length unknown values
+
+	var s = make(Series, length)
+
+	for l := range s {
+		s[l] = math.NaN()
+	}
+
+	return s
+
+}
+
+func (rrdPtr *Rrd) DataPoints() (int) {
+
+	// return the number of data points (data sources) stored in the Rrd
+
+	if ((*rrdPtr).Storage == Flat) {
+		return len((*rrdPtr).FD)
+	}
+
+	return int((*rrdPtr).MinimumDataPoints)
+
+}
+
+func (rrdPtr *Rrd) Value(step uint64, ds int) (float64, bool) {
+
+	// return the value of data point ds at step counted from FirstUpdateTs
+	// false is returned when the value is unknown
+
+	if ((*rrdPtr).TotalSteps == 0 || step >= (*rrdPtr).TotalSteps || ds < 0) {
+		return math.NaN(), false
+	}
+
+	var slot = step_slot(rrdPtr, step)
+
+	if ((*rrdPtr).Storage == Flat) {
+
+		if (ds >= len((*rrdPtr).FD) || slot >= uint64(len((*rrdPtr).FD[ds]))) {
+			return math.NaN(), false
+		}
+
+		var v = (*rrdPtr).FD[ds][slot]
+
+		return v, !math.IsNaN(v)
+
+	}
+
+	if (slot >= uint64(len((*rrdPtr).D)) || ds >= len((*rrdPtr).D[slot]) || (*rrdPtr).D[slot][ds] == nil) {
+		return math.NaN(), false
+	}
+
+	return (*(*rrdPtr).D[slot][ds]), true
+
+}
+
+func (rrdPtr *Rrd) SetValue(step uint64, ds int, v float64) {
+
+	// set the value of data point ds at step counted from FirstUpdateTs
+	// NaN sets an unknown value
+
+	if ((*rrdPtr).TotalSteps == 0 || step >= (*rrdPtr).TotalSteps || ds < 0) {
+		return
+	}
+
+	var slot = step_slot(rrdPtr, step)
+	mark_dirty(rrdPtr, slot)
+
+	if ((*rrdPtr).Storage == Flat) {
+		set_series(&(*rrdPtr).FD, (*rrdPtr).TotalSteps, slot, ds, v)
+		return
+	}
+
+	set_step(&(*rrdPtr).D, (*rrdPtr).TotalSteps, slot, ds, v, int((*rrdPtr).MinimumDataPoints))
+
+}
+
+func (rrdPtr *Rrd) Rate(step uint64, ds int) (float64, bool) {
+
+	// return the rate per second of data point ds at step counted from FirstUpdateTs
+	// false is returned when the rate is unknown or the Rrd has no rates
+
+	if ((*rrdPtr).TotalSteps == 0 || step >= (*rrdPtr).TotalSteps || ds < 0) {
+		return math.NaN(), false
+	}
+
+	var slot = step_slot(rrdPtr, step)
+
+	if ((*rrdPtr).Storage == Flat) {
+
+		if (ds >= len((*rrdPtr).FR) || slot >= uint64(len((*rrdPtr).FR[ds]))) {
+			return math.NaN(), false
+		}
+
+		var v = (*rrdPtr).FR[ds][slot]
+
+		return v, !math.IsNaN(v)
+
+	}
+
+	if (slot >= uint64(len((*rrdPtr).R)) || ds >= len((*rrdPtr).R[slot]) || (*rrdPtr).R[slot][ds] == nil) {
+		return math.NaN(), false
+	}
+
+	return (*(*rrdPtr).R[slot][ds]), true
+
+}
+
+func (rrdPtr *Rrd) SetRate(step uint64, ds int, v float64) {
+
+	// set the rate per second of data point ds at step counted from FirstUpdateTs
+	// NaN sets an unknown rate
+
+	if ((*rrdPtr).TotalSteps == 0 || step >= (*rrdPtr).TotalSteps || ds < 0) {
+		return
+	}
+
+	var slot = step_slot(rrdPtr, step)
+	mark_dirty(rrdPtr, slot)
+
+	if ((*rrdPtr).Storage == Flat) {
+		set_series(&(*rrdPtr).FR, (*rrdPtr).TotalSteps, slot, ds, v)
+		return
+	}
+
+	set_step(&(*rrdPtr).R, (*rrdPtr).TotalSteps, slot, ds, v, int((*rrdPtr).MinimumDataPoints))
+
+}
+
+func (rrdPtr *Rrd) HasRates() (bool) {
+
+	// return true if the Rrd stores rates
+
+	if ((*rrdPtr).Storage == Flat) {
+		return (*rrdPtr).FR != nil
+	}
+
+	return (*rrdPtr).R != nil
+
+}
+
+func (rrdPtr *Rrd) StepKnown(step uint64) (bool) {
+
+	// return true if any value of step is known
+
+	for ds := 0; ds < (*rrdPtr).DataPoints(); ds++ {
+
+		var _, known = (*rrdPtr).Value(step, ds)
+
+		if (known == true) {
+			return true
+		}
+
+	}
+
+	return false
+
+}
+
+func set_series(series *[]Series, total_steps uint64, slot uint64, ds int, v float64) {
+
+	// set the value of ds at slot in flat storage
+
+	if (ds >= len(*series) && math.IsNaN(v)) {
+		// unknown values are not stored for a data point that does not exist yet
+		return
+	}
+
+	for (ds >= len(*series)) {
+		*series = append(*series, new_series(total_steps))
+	}
+
+	if (uint64(len((*series)[ds])) < total_steps) {
+		(*series)[ds] = append((*series)[ds], new_series(total_steps - uint64(len((*series)[ds])))...)
+	}
+
+	(*series)[ds][slot] = v
+
+}
+
+func set_step(steps *[][]*float64, total_steps uint64, slot uint64, ds int, v float64, minimum_data_points int) {
+
+	// set the value of ds at slot in pointer storage
+	// a step is allocated when the first known value is set
+
+	if (*steps == nil) {
+		*steps = make([][]*float64, total_steps)
+	}
+
+	if (math.IsNaN(v)) {
+
+		if (ds < len((*steps)[slot])) {
+			(*steps)[slot][ds] = nil
+		}
+
+		return
+
+	}
+
+	var length = ds + 1
+	if (minimum_data_points > length) {
+		length = minimum_data_points
+	}
+
+	for (len((*steps)[slot]) < length) {
+		// add nil for each new field
+		(*steps)[slot] = append((*steps)[slot], nil)
+	}
+
+	(*steps)[slot][ds] = &v
+
+}
+
+func clear_step(rrdPtr *Rrd, step uint64) {
+
+	// set every value and rate of step to unknown
+
+	var slot = step_slot(rrdPtr, step)
+	mark_dirty(rrdPtr, slot)
+
+	if ((*rrdPtr).Storage == Flat) {
+
+		for ds := range (*rrdPtr).FD {
+			(*rrdPtr).FD[ds][slot] = math.NaN()
+		}
+
+		for ds := range (*rrdPtr).FR {
+			(*rrdPtr).FR[ds][slot] = math.NaN()
+		}
+
+		return
+
+	}
+
+	(*rrdPtr).D[slot] = nil
+	if ((*rrdPtr).R != nil) {
+		(*rrdPtr).R[slot] = nil
+	}
+
+}
+
+func clear_rates(rrdPtr *Rrd, step uint64) {
+
+	// set every rate of step to unknown
+
+	var slot = step_slot(rrdPtr, step)
+	mark_dirty(rrdPtr, slot)
+
+	if ((*rrdPtr).Storage == Flat) {
+
+		for ds := range (*rrdPtr).FR {
+			(*rrdPtr).FR[ds][slot] = math.NaN()
+		}
+
+		return
+
+	}
+
+	if ((*rrdPtr).R != nil) {
+		(*rrdPtr).R[slot] = nil
+	}
+
+}
+
+func reset_storage(rrdPtr *Rrd, data_points int) {
+
+	// allocate empty storage for TotalSteps of data_points values in the Storage layout of the Rrd
+
+	var rates = is_rate_type((*rrdPtr).DataType)
+
+	for slot := uint64(0); slot < (*rrdPtr).TotalSteps; slot++ {
+		mark_dirty(rrdPtr, slot)
+	}
+
+	(*rrdPtr).D = nil
+	(*rrdPtr).R = nil
+	(*rrdPtr).FD = nil
+	(*rrdPtr).FR = nil
+
+	if ((*rrdPtr).Storage == Flat) {
+
+		(*rrdPtr).FD = make([]Series, data_points)
+		for ds := range (*rrdPtr).FD {
+			(*rrdPtr).FD[ds] = new_series((*rrdPtr).TotalSteps)
+		}
+
+		if (rates == true) {
+			(*rrdPtr).FR = make([]Series, data_points)
+			for ds := range (*rrdPtr).FR {
+				(*rrdPtr).FR[ds] = new_series((*rrdPtr).TotalSteps)
+			}
+		}
+
+		return
+
+	}
+
+	(*rrdPtr).D = make([][]*float64, (*rrdPtr).TotalSteps)
+	if (rates == true) {
+		(*rrdPtr).R = make([][]*float64, (*rrdPtr).TotalSteps)
+	}
+
+}
+
+func mark_dirty(rrdPtr *Rrd, slot uint64) {
+
+	// report a written slot to the dirty hook of the Rrd
+
+	if ((*rrdPtr).dirty != nil) {
+		(*rrdPtr).dirty(slot)
+	}
+
+}
+
+func extend_data_points(rrdPtr *Rrd, data_points int) {
+
+	// make all stored steps at least data_points long
+
+	if ((*rrdPtr).Storage == Flat) {
+
+		for (len((*rrdPtr).FD) < data_points && (*rrdPtr).FD != nil) {
+			(*rrdPtr).FD = append((*rrdPtr).FD, new_series((*rrdPtr).TotalSteps))
+		}
+
+		for (len((*rrdPtr).FR) < data_points && (*rrdPtr).FR != nil) {
+			(*rrdPtr).FR = append((*rrdPtr).FR, new_series((*rrdPtr).TotalSteps))
+		}
+
+		return
+
+	}
+
+	for n := range (*rrdPtr).D {
+		(*rrdPtr).D[n] = extend_step((*rrdPtr).D[n], data_points)
+	}
+
+	for n := range (*rrdPtr).R {
+		(*rrdPtr).R[n] = extend_step((*rrdPtr).R[n], data_points)
+	}
+
+}
+
+func extend_step(step []*float64, length int) ([]*float64) {
+
+	// add nil values to step until it has length values
+	// [] and nil steps are not extended
+
+	if (len(step) == 0) {
+		return step
+	}
+
+	for (len(step) < length) {
+		// add nil for each new field
+		step = append(step, nil)
+	}
+
+	return step
+
+}
+
+func ToFlat(rrdPtr *Rrd) {
+
+	// convert the D and R values of the Rrd to FD and FR
+	// used to load documents that were stored with the Pointer layout
+
+	if ((*rrdPtr).Storage == Flat) {
+		return
+	}
+
+	var data_points = int((*rrdPtr).MinimumDataPoints)
+	for n := range (*rrdPtr).D {
+		if (len((*rrdPtr).D[n]) > data_points) {
+			data_points = len((*rrdPtr).D[n])
+		}
+	}
+
+	(*rrdPtr).FD = pointers_to_series((*rrdPtr).D, (*rrdPtr).TotalSteps, data_points)
+	(*rrdPtr).FR = nil
+	if ((*rrdPtr).R != nil) {
+		(*rrdPtr).FR = pointers_to_series((*rrdPtr).R, (*rrdPtr).TotalSteps, data_points)
+	}
+
+	(*rrdPtr).D = nil
+	(*rrdPtr).R = nil
+	(*rrdPtr).Storage = Flat
+
+}
+
+func ToPointer(rrdPtr *Rrd) {
+
+	// convert the FD and FR values of the Rrd to D and R
+	// used to store documents that are read by the Pointer layout
+
+	if ((*rrdPtr).Storage == Pointer) {
+		return
+	}
+
+	(*rrdPtr).D = series_to_pointers((*rrdPtr).FD, (*rrdPtr).TotalSteps)
+	(*rrdPtr).R = nil
+	if ((*rrdPtr).FR != nil) {
+		(*rrdPtr).R = series_to_pointers((*rrdPtr).FR, (*rrdPtr).TotalSteps)
+	}
+
+	if (uint64(len((*rrdPtr).FD)) > (*rrdPtr).MinimumDataPoints) {
+		(*rrdPtr).MinimumDataPoints = uint64(len((*rrdPtr).FD))
+	}
+
+	(*rrdPtr).FD = nil
+	(*rrdPtr).FR = nil
+	(*rrdPtr).Storage = Pointer
+
+}
+
+func pointers_to_series(steps [][]*float64, total_steps uint64, data_points int) ([]Series) {
+
+	if (steps == nil) {
+		return nil
+	}
+
+	var series = make([]Series, data_points)
+
+	for ds := range series {
+
+		series[ds] = new_series(total_steps)
+
+		for slot := range steps {
+
+			if (slot < len(series[ds]) && ds < len(steps[slot]) && steps[slot][ds] != nil) {
+				series[ds][slot] = (*steps[slot][ds])
+			}
+
+		}
+
+	}
+
+	return series
+
+}
+
+func series_to_pointers(series []Series, total_steps uint64) ([][]*float64) {
+
+	if (series == nil) {
+		return nil
+	}
+
+	var steps = make([][]*float64, total_steps)
+
+	for slot := range steps {
+
+		var known = false
+
+		for ds := range series {
+			if (slot < len(series[ds]) && !math.IsNaN(series[ds][slot])) {
+				known = true
+				break
+			}
+		}
+
+		if (known == false) {
+			// steps without data are nil
+			continue
+		}
+
+		// one allocation for the values of this step
+		var values = make([]float64, len(series))
+		steps[slot] = make([]*float64, len(series))
+
+		for ds := range series {
+
+			if (slot < len(series[ds]) && !math.IsNaN(series[ds][slot])) {
+				values[ds] = series[ds][slot]
+				steps[slot][ds] = &values[ds]
+			}
+
+		}
+
+	}
+
+	return steps
+
+}
@@ -0,0 +1,512 @@
+package rrd
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"time"
+	"slices"
+	"errors"
+	"encoding/binary"
+)
+
+// the Graphite Whisper format, big endian
+//
+// metadata	16 bytes, aggregation type uint32, max retention uint32, xFilesFactor float32, archive count uint32
+// archive info	12 bytes for each archive, offset uint32, seconds per point uint32, points uint32
+// archives	12 bytes for each point of each archive, timestamp uint32, value float64
+//
+// a Whisper file has one data source, a point is stored at the slot of its timestamp counted from the timestamp in the first slot
+
+const (
+	whisper_metadata_size = 16
+	whisper_archive_info_size = 12
+	whisper_point_size = 12
+)
+
+var (
+	// returned when a file is not a valid Whisper file or a Rrd cannot be stored as a Whisper file
+	ErrInvalidWhisper = errors.New("invalid whisper file")
+)
+
+type whisper_archive struct {
+	offset			uint32
+	seconds_per_point	uint32
+	points			uint32
+	// the timestamp and value of each valid point, oldest first
+	timestamps		[]uint32
+	values			[]float64
+}
+
+func ImportWhisper(r io.Reader) (*Rrd, error) {
+
+	// read a Whisper file into a Gauge Rrd with one data point
+	// a Whisper file has one data source, it is data source 0 of the Rrd and has no DataSources definition
+	// the first archive is used, it has the fewest seconds per point
+	// Interval is the seconds per point and TotalSteps is the points of the archive
+
+	var aggregation, _, archives, err = whisper_decode(r)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var rrd_out Rrd
+	rrd_out.DataType = Gauge
+	rrd_out.Consolidation = aggregation
+
+	whisper_fill(&rrd_out, archives[0], 0)
+
+	return &rrd_out, nil
+
+}
+
+func ImportWhisperSet(r io.Reader) (*RrdSet, error) {
+
+	// read a Whisper file into a Gauge RrdSet with an Archive for each Whisper archive
+	// the Whisper archive points at and after the newest point of the first archive are consolidated again by the RrdSet
+
+	var aggregation, xff, archives, err = whisper_decode(r)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var step = time.Duration(archives[0].seconds_per_point) * time.Second
+	var set_archives = make([]Archive, len(archives))
+
+	for l := range archives {
+
+		if (archives[l].seconds_per_point % archives[0].seconds_per_point != 0) {
+			return nil, fmt.Errorf("%w, the seconds per point of each archive must be a multiple of the first archive", ErrInvalidWhisper)
+		}
+
+		set_archives[l] = Archive{Steps: uint64(archives[l].seconds_per_point / archives[0].seconds_per_point), Rows: uint64(archives[l].points), Consolidation: aggregation, Xff: xff}
+
+	}
+
+	var set = NewRrdSet(step, Gauge, set_archives...)
+	(*set).Primary.Consolidation = aggregation
+	(*set).Primary.MinimumDataPoints = 1
+
+	var first = archives[0]
+
+	if (len(first.timestamps) == 0) {
+		// there is no data
+		return set, nil
+	}
+
+	// the primary Rrd is the newest points of the first archive
+	whisper_fill(&(*set).Primary, first, 0)
+
+	// the newest primary step is not complete
+	var newest = time.Unix(int64(first.timestamps[len(first.timestamps) - 1]), 0)
+	var origin = time.Unix(0, 0)
+	var next_step = newest
+	(*set).Origin = &origin
+	(*set).NextStep = &next_step
+
+	for l := range (*set).Archives {
+
+		var arc = (*set).Archives[l]
+
+		// the archive step that contains the newest primary step is consolidated from the first archive
+		var archive_start = xml_align(newest, arc.Rrd.Interval)
+
+		whisper_fill(&arc.Rrd, archives[l], uint32(archive_start.Unix()))
+
+		for n := range first.timestamps {
+
+			var ts = time.Unix(int64(first.timestamps[n]), 0)
+
+			if (ts.Before(archive_start) || ts.Equal(newest)) {
+				continue
+			}
+
+			var err = add_archive(arc, step, origin, ts, []float64{first.values[n]})
+			if (err != nil) {
+				return nil, err
+			}
+
+		}
+
+	}
+
+	return set, nil
+
+}
+
+func whisper_decode(r io.Reader) (uint8, float64, []whisper_archive, error) {
+
+	// read the consolidation, xFilesFactor and archives of a Whisper file
+
+	var b, err = io.ReadAll(r)
+	if (err != nil) {
+		return 0, 0, nil, err
+	}
+
+	if (len(b) < whisper_metadata_size) {
+		return 0, 0, nil, fmt.Errorf("%w, the file is shorter than the metadata", ErrInvalidWhisper)
+	}
+
+	var aggregation_type = binary.BigEndian.Uint32(b[0:])
+	var xff = float64(math.Float32frombits(binary.BigEndian.Uint32(b[8:])))
+	var archive_count = binary.BigEndian.Uint32(b[12:])
+
+	var consolidation uint8
+
+	switch aggregation_type {
+		case 1:
+			consolidation = Average
+		case 2:
+			consolidation = Sum
+		case 3:
+			consolidation = Last
+		case 4:
+			consolidation = Max
+		case 5:
+			consolidation = Min
+		case 6:
+			// avg_zero, unknown points are 0
+			consolidation = Average
+		case 7:
+			// absmax
+			consolidation = Max
+		case 8:
+			// absmin
+			consolidation = Min
+		default:
+			return 0, 0, nil, fmt.Errorf("%w, aggregation type %d is not supported", ErrInvalidWhisper, aggregation_type)
+	}
+
+	if (archive_count == 0 || uint64(len(b)) < whisper_metadata_size + uint64(archive_count) * whisper_archive_info_size) {
+		return 0, 0, nil, fmt.Errorf("%w, the file has no archives or is shorter than the archive info", ErrInvalidWhisper)
+	}
+
+	var archives = make([]whisper_archive, archive_count)
+
+	for l := range archives {
+
+		var info = b[whisper_metadata_size + l * whisper_archive_info_size:]
+		archives[l].offset = binary.BigEndian.Uint32(info[0:])
+		archives[l].seconds_per_point = binary.BigEndian.Uint32(info[4:])
+		archives[l].points = binary.BigEndian.Uint32(info[8:])
+
+		if (archives[l].seconds_per_point == 0 || archives[l].points == 0) {
+			return 0, 0, nil, fmt.Errorf("%w, archive %d has no seconds per point or no points", ErrInvalidWhisper, l)
+		}
+
+		if (uint64(len(b)) < uint64(archives[l].offset) + uint64(archives[l].points) * whisper_point_size) {
+			return 0, 0, nil, fmt.Errorf("%w, the file is shorter than archive %d", ErrInvalidWhisper, l)
+		}
+
+		whisper_points(b[archives[l].offset:], &archives[l])
+
+	}
+
+	return consolidation, xff, archives, nil
+
+}
+
+func whisper_points(b []byte, archive *whisper_archive) {
+
+	// read the valid points of the archive in order
+	// a point is valid when it is at the slot of its timestamp and within points of the newest point
+
+	var spp = (*archive).seconds_per_point
+	var base = binary.BigEndian.Uint32(b[0:])
+
+	if (base == 0) {
+		// the archive has no data
+		return
+	}
+
+	var newest uint32
+
+	for slot := uint32(0); slot < (*archive).points; slot++ {
+
+		var ts = binary.BigEndian.Uint32(b[slot * whisper_point_size:])
+
+		if (ts == 0 || ts % spp != base % spp) {
+			continue
+		}
+
+		// the slot of the timestamp counted from base
+		var distance = (int64(ts) - int64(base)) / int64(spp)
+		var expected = ((distance % int64((*archive).points)) + int64((*archive).points)) % int64((*archive).points)
+
+		if (uint32(expected) != slot) {
+			continue
+		}
+
+		if (ts > newest) {
+			newest = ts
+		}
+
+		(*archive).timestamps = append((*archive).timestamps, ts)
+		(*archive).values = append((*archive).values, math.Float64frombits(binary.BigEndian.Uint64(b[slot * whisper_point_size + 4:])))
+
+	}
+
+	// remove points older than the archive retention from the newest point
+	var oldest = int64(newest) - int64(spp) * int64((*archive).points - 1)
+	var timestamps []uint32
+	var values []float64
+
+	for n := range (*archive).timestamps {
+		if (int64((*archive).timestamps[n]) >= oldest) {
+			timestamps = append(timestamps, (*archive).timestamps[n])
+			values = append(values, (*archive).values[n])
+		}
+	}
+
+	// oldest first
+	var order = make([]int, len(timestamps))
+	for n := range order {
+		order[n] = n
+	}
+	slices.SortFunc(order, func(a int, b int) (int) {
+		return int(int64(timestamps[a]) - int64(timestamps[b]))
+	})
+
+	(*archive).timestamps = make([]uint32, len(order))
+	(*archive).values = make([]float64, len(order))
+
+	for n := range order {
+		(*archive).timestamps[n] = timestamps[order[n]]
+		(*archive).values[n] = values[order[n]]
+	}
+
+}
+
+func whisper_fill(rrdPtr *Rrd, archive whisper_archive, before uint32) {
+
+	// store the points of the archive in the Rrd, the newest point is the last step
+	// points at or after before are not stored when before is not 0
+	// the Rrd has the Interval of the archive and one data point, a Rrd with TotalSteps is not changed
+
+	(*rrdPtr).Interval = time.Duration(archive.seconds_per_point) * time.Second
+	(*rrdPtr).MinimumDataPoints = 1
+	if ((*rrdPtr).TotalSteps == 0) {
+		(*rrdPtr).TotalSteps = uint64(archive.points)
+	}
+
+	var timestamps = archive.timestamps
+	if (before != 0) {
+		for (len(timestamps) > 0 && timestamps[len(timestamps) - 1] >= before) {
+			timestamps = timestamps[:len(timestamps) - 1]
+		}
+	}
+
+	if (len(timestamps) == 0) {
+		return
+	}
+
+	var newest = time.Unix(int64(timestamps[len(timestamps) - 1]), 0)
+	var first = newest.Add(-(*rrdPtr).Interval * time.Duration((*rrdPtr).TotalSteps - 1))
+
+	(*rrdPtr).FirstUpdateTs = &first
+	(*rrdPtr).LastUpdate = newest
+	(*rrdPtr).CurrentAvgCount = 1
+	(*rrdPtr).Head = 0
+	reset_storage(rrdPtr, 1)
+
+	for n := range timestamps {
+
+		var step = step_at(rrdPtr, time.Unix(int64(timestamps[n]), 0))
+		if (step < 0) {
+			continue
+		}
+
+		(*rrdPtr).SetValue(uint64(step), 0, archive.values[n])
+
+	}
+
+	var last = archive.values[len(timestamps) - 1]
+	(*rrdPtr).LastUpdateDataPoint = []*float64{&last}
+
+}
+
+func ExportWhisper(w io.Writer, rrdPtr *Rrd, ds int) (error) {
+
+	// write data point ds of the Rrd as a Whisper file with one archive
+	// the other data points are not written, write a file for each data point of a Rrd with more than one
+	// Counter, Derive and Absolute Rrd are written as the rates
+
+	var spp, err = whisper_seconds_per_point((*rrdPtr).Interval)
+	if (err != nil) {
+		return err
+	}
+
+	aggregation, err := whisper_aggregation((*rrdPtr).Consolidation)
+	if (err != nil) {
+		return err
+	}
+
+	return whisper_write(w, aggregation, 0.5, []uint32{spp}, []*Rrd{rrdPtr}, ds, []bool{(*rrdPtr).HasRates()})
+
+}
+
+func ExportWhisperSet(w io.Writer, setPtr *RrdSet, ds int) (error) {
+
+	// write data point ds of the RrdSet as a Whisper file with an archive for each Archive
+	// Whisper has one aggregation and xFilesFactor, the Archives must have the same Consolidation and the Xff of the first Archive is used
+
+	if (len((*setPtr).Archives) == 0) {
+		return fmt.Errorf("%w, the RrdSet has no Archives", ErrInvalidWhisper)
+	}
+
+	var archives = slices.Clone((*setPtr).Archives)
+	slices.SortFunc(archives, func(a *Archive, b *Archive) (int) {
+		return int(int64(a.Steps) - int64(b.Steps))
+	})
+
+	var rrds []*Rrd
+	var spps []uint32
+	var rates []bool
+
+	for l := range archives {
+
+		if (archives[l].Consolidation != archives[0].Consolidation) {
+			return fmt.Errorf("%w, the Archives have more than one Consolidation", ErrInvalidWhisper)
+		}
+
+		var spp, err = whisper_seconds_per_point((*setPtr).Interval * time.Duration(archives[l].Steps))
+		if (err != nil) {
+			return err
+		}
+
+		rrds = append(rrds, &archives[l].Rrd)
+		spps = append(spps, spp)
+		rates = append(rates, false)
+
+	}
+
+	var aggregation, err = whisper_aggregation(archives[0].Consolidation)
+	if (err != nil) {
+		return err
+	}
+
+	return whisper_write(w, aggregation, archives[0].Xff, spps, rrds, ds, rates)
+
+}
+
+func whisper_write(w io.Writer, aggregation uint32, xff float64, spps []uint32, rrds []*Rrd, ds int, rates []bool) (error) {
+
+	// write a Whisper file with an archive of each Rrd
+	// the points, retention and offsets of the file are uint32
+
+	var header_size = whisper_metadata_size + len(rrds) * whisper_archive_info_size
+	var size = uint64(header_size)
+	var max_retention uint64
+
+	for l := range rrds {
+
+		var total_steps = (*rrds[l]).TotalSteps
+		if (total_steps > math.MaxUint32) {
+			return fmt.Errorf("%w, TotalSteps %d is more than the points of an archive", ErrInvalidWhisper, total_steps)
+		}
+
+		var retention = uint64(spps[l]) * total_steps
+		if (retention > math.MaxUint32) {
+			return fmt.Errorf("%w, the retention of %d seconds is more than the max retention", ErrInvalidWhisper, retention)
+		}
+
+		max_retention = max(max_retention, retention)
+
+		size += total_steps * whisper_point_size
+		if (size > math.MaxUint32) {
+			return fmt.Errorf("%w, the archives are more than the offsets of the file", ErrInvalidWhisper)
+		}
+
+	}
+
+	var b = make([]byte, size)
+
+	binary.BigEndian.PutUint32(b[0:], aggregation)
+	binary.BigEndian.PutUint32(b[4:], uint32(max_retention))
+	binary.BigEndian.PutUint32(b[8:], math.Float32bits(float32(xff)))
+	binary.BigEndian.PutUint32(b[12:], uint32(len(rrds)))
+
+	var offset = header_size
+
+	for l := range rrds {
+
+		var rrdPtr = rrds[l]
+		var info = b[whisper_metadata_size + l * whisper_archive_info_size:]
+
+		binary.BigEndian.PutUint32(info[0:], uint32(offset))
+		binary.BigEndian.PutUint32(info[4:], spps[l])
+		binary.BigEndian.PutUint32(info[8:], uint32((*rrdPtr).TotalSteps))
+
+		// the first known step is the first slot, the slot of each step is counted from it
+		var base uint32
+
+		for n := uint64(0); n < (*rrdPtr).TotalSteps && (*rrdPtr).FirstUpdateTs != nil; n++ {
+
+			var v float64
+			var known bool
+
+			if (rates[l] == true) {
+				v, known = (*rrdPtr).Rate(n, ds)
+			} else {
+				v, known = (*rrdPtr).Value(n, ds)
+			}
+
+			if (known == false) {
+				continue
+			}
+
+			// Whisper timestamps are a multiple of the seconds per point
+			var ts = uint32(xml_align(step_time(rrdPtr, n), (*rrdPtr).Interval).Unix())
+
+			if (base == 0) {
+				base = ts
+			}
+
+			var slot = ((ts - base) / spps[l]) % uint32((*rrdPtr).TotalSteps)
+			var point = b[offset + int(slot) * whisper_point_size:]
+
+			binary.BigEndian.PutUint32(point[0:], ts)
+			binary.BigEndian.PutUint64(point[4:], math.Float64bits(v))
+
+		}
+
+		offset += int((*rrdPtr).TotalSteps) * whisper_point_size
+
+	}
+
+	var _, err = w.Write(b)
+
+	return err
+
+}
+
+func whisper_seconds_per_point(interval time.Duration) (uint32, error) {
+
+	if (interval < time.Second || interval % time.Second != 0) {
+		return 0, fmt.Errorf("%w, Interval %s is not whole seconds", ErrInvalidWhisper, interval.String())
+	}
+
+	return uint32(interval / time.Second), nil
+
+}
+
+func whisper_aggregation(consolidation uint8) (uint32, error) {
+
+	// return the Whisper aggregation type of the consolidation
+
+	switch consolidation {
+		case Average:
+			return 1, nil
+		case Sum:
+			return 2, nil
+		case Last:
+			return 3, nil
+		case Max:
+			return 4, nil
+		case Min:
+			return 5, nil
+	}
+
+	return 0, fmt.Errorf("%w, consolidation %d is not supported", ErrInvalidWhisper, consolidation)
+
+}
@@ -0,0 +1,181 @@
+package rrd
+
+import (
+	"time"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWhisperRoundTrip(t *testing.T) {
+
+	// the steps wrap around TotalSteps and one step is unknown
+
+	var r = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: Gauge, Consolidation: Max}
+	var base = time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	for n := 0; n < 12; n++ {
+
+		if (n == 9) {
+			continue
+		}
+
+		var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute), []float64{float64(n) * 1.5}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	var b bytes.Buffer
+
+	var err = ExportWhisper(&b, &r, 0)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportWhisper(&b)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	if (imported.Interval != r.Interval || imported.TotalSteps != r.TotalSteps || imported.Consolidation != r.Consolidation) {
+		t.Fatalf("Interval %s, TotalSteps %d and Consolidation %d, want %s, %d and %d", imported.Interval, imported.TotalSteps, imported.Consolidation, r.Interval, r.TotalSteps, r.Consolidation)
+	}
+
+	check_same_steps(t, &r, imported)
+
+}
+
+func TestWhisperSetRoundTrip(t *testing.T) {
+
+	// each archive of the Whisper file is an Archive with the same steps
+	// the newest point is the primary step that is not complete, it is consolidated into the Archives by the next update
+
+	var set = NewRrdSet(time.Minute, Gauge, Archive{Steps: 1, Rows: 10}, Archive{Steps: 5, Rows: 4})
+	var base = time.Unix(1700000000, 0).Truncate(time.Hour)
+
+	for n := 0; n <= 30; n++ {
+
+		var err = UpdateRrdSetAt(base.Add(time.Duration(n) * time.Minute), GetUpdateValues(float64(n)), set)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	var b bytes.Buffer
+
+	var err = ExportWhisperSet(&b, set, 0)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportWhisperSet(&b)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	err = UpdateRrdSetAt(base.Add(30 * time.Minute), GetUpdateValues(30.0), imported)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	if (len(imported.Archives) != len(set.Archives)) {
+		t.Fatalf("%d Archives, want %d", len(imported.Archives), len(set.Archives))
+	}
+
+	for l := range set.Archives {
+
+		var arc = &set.Archives[l].Rrd
+		var imported_arc = &imported.Archives[l].Rrd
+
+		if (imported_arc.Interval != arc.Interval || imported_arc.TotalSteps != arc.TotalSteps) {
+			t.Fatalf("Archive %d has Interval %s and TotalSteps %d, want %s and %d", l, imported_arc.Interval, imported_arc.TotalSteps, arc.Interval, arc.TotalSteps)
+		}
+
+		check_same_steps(t, arc, imported_arc)
+
+	}
+
+}
+
+func TestWhisperDataPoint(t *testing.T) {
+
+	// a Whisper file has one data source, data point 1 of the Rrd is data point 0 of the import
+
+	var r = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: Gauge}
+	var base = time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	var want = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: Gauge}
+
+	for n := 0; n < 12; n++ {
+
+		var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute), []float64{float64(n), float64(n) * 10}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+		err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute), []float64{float64(n) * 10}, &want)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	var b bytes.Buffer
+
+	var err = ExportWhisper(&b, &r, 1)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportWhisper(&b)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	if (imported.DataPoints() != 1) {
+		t.Fatalf("%d data points, want 1", imported.DataPoints())
+	}
+
+	check_same_steps(t, &want, imported)
+
+}
+
+func TestWhisperUint32(t *testing.T) {
+
+	// the points and retention of an archive are uint32, the Rrd is not written
+
+	var tests = []struct {
+		name		string
+		interval	time.Duration
+		total_steps	uint64
+	}{
+		{"points", time.Second, 1 << 32},
+		{"retention", time.Hour, 1 << 21},
+		{"retention of the seconds per point", (1 << 31) * time.Second, 2},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			var r = Rrd{Interval: test.interval, TotalSteps: test.total_steps, DataType: Gauge}
+
+			var b bytes.Buffer
+
+			var err = ExportWhisper(&b, &r, 0)
+			if (errors.Is(err, ErrInvalidWhisper) == false) {
+				t.Fatalf("error %v, want ErrInvalidWhisper", err)
+			}
+
+			if (b.Len() != 0) {
+				t.Errorf("%d bytes were written", b.Len())
+			}
+
+		})
+
+	}
+
+}
@@ -0,0 +1,949 @@
+package rrd
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"time"
+	"regexp"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/xml"
+)
+
+// the rrdtool dump XML format
+// rrdtool rows end at a multiple of the row interval, a Rrd is exported with rows ending at the start of each step
+// the step that contains LastUpdate is the PDP (primary data point) that is not complete
+
+type xml_rrd struct {
+	XMLName			xml.Name	`xml:"rrd"`
+	Version			string		`xml:"version"`
+	Step			string		`xml:"step"`
+	LastUpdate		string		`xml:"lastupdate"`
+	Ds			[]xml_ds	`xml:"ds"`
+	Rra			[]xml_rra	`xml:"rra"`
+}
+
+type xml_ds struct {
+	Name			string		`xml:"name"`
+	Type			string		`xml:"type"`
+	MinimalHeartbeat	string		`xml:"minimal_heartbeat"`
+	Min			string		`xml:"min"`
+	Max			string		`xml:"max"`
+	LastDs			string		`xml:"last_ds"`
+	Value			string		`xml:"value"`
+	UnknownSec		string		`xml:"unknown_sec"`
+}
+
+type xml_rra struct {
+	Cf			string		`xml:"cf"`
+	PdpPerRow		string		`xml:"pdp_per_row"`
+	Xff			string		`xml:"params>xff"`
+	CdpPrep			[]xml_cdp	`xml:"cdp_prep>ds"`
+	Rows			[]xml_row	`xml:"database>row"`
+}
+
+type xml_cdp struct {
+	Value			string		`xml:"value"`
+	UnknownDatapoints	string		`xml:"unknown_datapoints"`
+}
+
+type xml_row struct {
+	V			[]string	`xml:"v"`
+}
+
+var xml_ds_name = regexp.MustCompile("^[a-zA-Z0-9_]{1,19}$")
+
+func ExportXML(w io.Writer, rrdPtr *Rrd) (error) {
+
+	// write the Rrd in the rrdtool dump XML format with one RRA of TotalSteps rows
+	// the rows are the steps before the step that contains LastUpdate, Counter, Derive and Absolute rows are the rates
+	// rrdtool aligns rows to multiples of the step from 1970, the row of LastUpdate is the rrdtool step that contains it
+	// a Rrd with FirstUpdateTs that is not aligned has each step written at the same distance from that row, less than one Interval earlier or later
+
+	var step, err = xml_step((*rrdPtr).Interval)
+	if (err != nil) {
+		return err
+	}
+
+	cf, err := xml_cf((*rrdPtr).Consolidation)
+	if (err != nil) {
+		return err
+	}
+
+	if ((*rrdPtr).FirstUpdateTs == nil) {
+		return errors.New("the Rrd has no data to export")
+	}
+
+	var x strings.Builder
+
+	err = xml_write_head(&x, rrdPtr, step)
+	if (err != nil) {
+		return err
+	}
+
+	// the start of the step that contains LastUpdate
+	var current = step_time(rrdPtr, uint64(step_at(rrdPtr, (*rrdPtr).LastUpdate)))
+
+	x.WriteString("\t<rra>\n")
+	fmt.Fprintf(&x, "\t\t<cf>%s</cf>\n", cf)
+	fmt.Fprintf(&x, "\t\t<pdp_per_row>1</pdp_per_row> <!-- %d seconds -->\n\n", step)
+	x.WriteString("\t\t<params>\n\t\t<xff>5.0000000000e-01</xff>\n\t\t</params>\n")
+	x.WriteString("\t\t<cdp_prep>\n")
+
+	for e := 0; e < (*rrdPtr).DataPoints(); e++ {
+		xml_write_cdp(&x, math.NaN(), 0)
+	}
+
+	x.WriteString("\t\t</cdp_prep>\n")
+
+	xml_write_rows(&x, rrdPtr, current, xml_align((*rrdPtr).LastUpdate, (*rrdPtr).Interval), (*rrdPtr).TotalSteps, (*rrdPtr).HasRates())
+
+	x.WriteString("\t</rra>\n</rrd>\n")
+
+	_, err = io.WriteString(w, x.String())
+
+	return err
+
+}
+
+func ExportXMLSet(w io.Writer, setPtr *RrdSet) (error) {
+
+	// write the RrdSet in the rrdtool dump XML format with an RRA for each Archive
+
+	var primaryPtr = &(*setPtr).Primary
+
+	var step, err = xml_step((*setPtr).Interval)
+	if (err != nil) {
+		return err
+	}
+
+	if ((*primaryPtr).FirstUpdateTs == nil) {
+		return errors.New("the RrdSet has no data to export")
+	}
+
+	var x strings.Builder
+
+	err = xml_write_head(&x, primaryPtr, step)
+	if (err != nil) {
+		return err
+	}
+
+	// the start of the primary step that contains LastUpdate
+	var current = step_time(primaryPtr, uint64(step_at(primaryPtr, (*primaryPtr).LastUpdate)))
+
+	for l := range (*setPtr).Archives {
+
+		var arc = (*setPtr).Archives[l]
+
+		cf, err := xml_cf(arc.Consolidation)
+		if (err != nil) {
+			return err
+		}
+
+		var archive_interval = (*setPtr).Interval * time.Duration(arc.Steps)
+
+		x.WriteString("\t<rra>\n")
+		fmt.Fprintf(&x, "\t\t<cf>%s</cf>\n", cf)
+		fmt.Fprintf(&x, "\t\t<pdp_per_row>%d</pdp_per_row> <!-- %d seconds -->\n\n", arc.Steps, step * int64(arc.Steps))
+		fmt.Fprintf(&x, "\t\t<params>\n\t\t<xff>%s</xff>\n\t\t</params>\n", xml_float(arc.Xff))
+		x.WriteString("\t\t<cdp_prep>\n")
+
+		// the archive step that is not complete
+		var archive_current = current
+		if (arc.AccStart != nil) {
+			archive_current = (*arc.AccStart)
+		} else if ((*setPtr).Origin != nil) {
+			archive_current = (*(*setPtr).Origin).Add(current.Sub((*(*setPtr).Origin)) / archive_interval * archive_interval)
+		}
+
+		for e := 0; e < (*primaryPtr).DataPoints(); e++ {
+
+			if (arc.AccStart == nil || e >= len(arc.Acc) || arc.AccKnown[e] == 0) {
+				xml_write_cdp(&x, math.NaN(), uint64(current.Sub(archive_current) / (*setPtr).Interval))
+				continue
+			}
+
+			var value = arc.Acc[e]
+			if (arc.Consolidation == Average) {
+				// rrdtool stores the sum of the primary steps
+				value *= float64(arc.AccKnown[e])
+			}
+
+			var elapsed = uint64(current.Sub(archive_current) / (*setPtr).Interval)
+			var unknown uint64
+			if (elapsed > arc.AccKnown[e]) {
+				unknown = elapsed - arc.AccKnown[e]
+			}
+
+			xml_write_cdp(&x, value, unknown)
+
+		}
+
+		x.WriteString("\t\t</cdp_prep>\n")
+
+		xml_write_rows(&x, &arc.Rrd, archive_current, xml_align((*primaryPtr).LastUpdate, archive_interval), arc.Rows, false)
+
+		x.WriteString("\t</rra>\n")
+
+	}
+
+	x.WriteString("</rrd>\n")
+
+	_, err = io.WriteString(w, x.String())
+
+	return err
+
+}
+
+func xml_write_head(x *strings.Builder, rrdPtr *Rrd, step int64) (error) {
+
+	// write the header and data sources with the PDP of the step that contains LastUpdate
+
+	x.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	x.WriteString("<!DOCTYPE rrd SYSTEM \"https://oss.oetiker.ch/rrdtool/rrdtool.dtd\">\n")
+	x.WriteString("<!-- Round Robin Database Dump -->\n")
+	x.WriteString("<rrd>\n")
+	x.WriteString("\t<version>0003</version>\n")
+	fmt.Fprintf(x, "\t<step>%d</step> <!-- Seconds -->\n", step)
+	fmt.Fprintf(x, "\t<lastupdate>%d</lastupdate> <!-- %s -->\n\n", (*rrdPtr).LastUpdate.Unix(), (*rrdPtr).LastUpdate.Format(time.RFC1123Z))
+
+	var data_type string
+	switch (*rrdPtr).DataType {
+		case Gauge:
+			data_type = "GAUGE"
+		case Counter:
+			data_type = "COUNTER"
+		case Derive:
+			data_type = "DERIVE"
+		case Absolute:
+			data_type = "ABSOLUTE"
+		default:
+			return errors.New("DataType " + data_type_string((*rrdPtr).DataType) + " is not supported by rrdtool")
+	}
+
+	// the seconds of the rrdtool step that contains LastUpdate
+	var current_step = uint64(step_at(rrdPtr, (*rrdPtr).LastUpdate))
+	var elapsed = (*rrdPtr).LastUpdate.Sub(xml_align((*rrdPtr).LastUpdate, (*rrdPtr).Interval)).Seconds()
+
+	for e := 0; e < (*rrdPtr).DataPoints(); e++ {
+
+		var ds = data_source(rrdPtr, e)
+
+		var name = ds.Name
+		if (name == "") {
+			name = "ds" + strconv.Itoa(e)
+		}
+
+		if (xml_ds_name.MatchString(name) == false) {
+			return errors.New("the data source name " + name + " is not valid for rrdtool, 1 to 19 characters of a-z, A-Z, 0-9 and _")
+		}
+
+		// a Heartbeat of 0 has no limit, rrdtool requires one
+		var heartbeat = int64(ds.Heartbeat / time.Second)
+		if (heartbeat <= 0) {
+			heartbeat = step * int64((*rrdPtr).TotalSteps)
+		}
+
+		var min = math.NaN()
+		if (ds.Min != nil) {
+			min = (*ds.Min)
+		}
+
+		var max = math.NaN()
+		if (ds.Max != nil) {
+			max = (*ds.Max)
+		}
+
+		var last_ds = "U"
+		if (e < len((*rrdPtr).LastUpdateDataPoint) && (*rrdPtr).LastUpdateDataPoint[e] != nil) {
+			last_ds = strconv.FormatFloat((*(*rrdPtr).LastUpdateDataPoint[e]), 'f', -1, 64)
+		}
+
+		// the PDP that is not complete is the value or rate multiplied by the known seconds
+		var v, known = (*rrdPtr).Value(current_step, e)
+		if ((*rrdPtr).HasRates() == true) {
+			v, known = (*rrdPtr).Rate(current_step, e)
+		}
+
+		var value = 0.0
+		var unknown_sec = int64(elapsed)
+		if (known == true) {
+			value = v * elapsed
+			unknown_sec = 0
+		}
+
+		x.WriteString("\t<ds>\n")
+		fmt.Fprintf(x, "\t\t<name> %s </name>\n", name)
+		fmt.Fprintf(x, "\t\t<type> %s </type>\n", data_type)
+		fmt.Fprintf(x, "\t\t<minimal_heartbeat>%d</minimal_heartbeat>\n", heartbeat)
+		fmt.Fprintf(x, "\t\t<min>%s</min>\n", xml_float(min))
+		fmt.Fprintf(x, "\t\t<max>%s</max>\n\n", xml_float(max))
+		x.WriteString("\t\t<!-- PDP Status -->\n")
+		fmt.Fprintf(x, "\t\t<last_ds>%s</last_ds>\n", last_ds)
+		fmt.Fprintf(x, "\t\t<value>%s</value>\n", xml_float(value))
+		fmt.Fprintf(x, "\t\t<unknown_sec> %d </unknown_sec>\n", unknown_sec)
+		x.WriteString("\t</ds>\n\n")
+
+	}
+
+	x.WriteString("\t<!-- Round Robin Archives -->\n")
+
+	return nil
+
+}
+
+func xml_write_cdp(x *strings.Builder, value float64, unknown uint64) {
+
+	x.WriteString("\t\t\t<ds>\n")
+	x.WriteString("\t\t\t<primary_value>NaN</primary_value>\n")
+	x.WriteString("\t\t\t<secondary_value>NaN</secondary_value>\n")
+	fmt.Fprintf(x, "\t\t\t<value>%s</value>\n", xml_float(value))
+	fmt.Fprintf(x, "\t\t\t<unknown_datapoints>%d</unknown_datapoints>\n", unknown)
+	x.WriteString("\t\t\t</ds>\n")
+
+}
+
+func xml_write_rows(x *strings.Builder, rrdPtr *Rrd, end time.Time, rrdtoolEnd time.Time, rows uint64, rates bool) {
+
+	// write rows steps of the Rrd that end at end
+	// the rows are labelled with the rrdtool steps that end at rrdtoolEnd, the step that contains lastupdate
+
+	x.WriteString("\t\t<database>\n")
+
+	for n := uint64(0); n < rows; n++ {
+
+		var start = end.Add(-(*rrdPtr).Interval * time.Duration(rows - n))
+		var row_end = rrdtoolEnd.Add(-(*rrdPtr).Interval * time.Duration(rows - n - 1))
+
+		fmt.Fprintf(x, "\t\t\t<!-- %s / %d --> <row>", row_end.Format("2006-01-02 15:04:05 MST"), row_end.Unix())
+
+		var step = int64(-1)
+		if ((*rrdPtr).FirstUpdateTs != nil) {
+			step = step_at(rrdPtr, start)
+		}
+
+		for e := 0; e < (*rrdPtr).DataPoints(); e++ {
+
+			var v = math.NaN()
+			if (step >= 0) {
+				if (rates == true) {
+					v, _ = (*rrdPtr).Rate(uint64(step), e)
+				} else {
+					v, _ = (*rrdPtr).Value(uint64(step), e)
+				}
+			}
+
+			fmt.Fprintf(x, "<v>%s</v>", xml_float(v))
+
+		}
+
+		x.WriteString("</row>\n")
+
+	}
+
+	x.WriteString("\t\t</database>\n")
+
+}
+
+func ImportXML(r io.Reader) (*Rrd, error) {
+
+	// read a rrdtool dump XML document into a Rrd
+	// the RRA with the fewest pdp_per_row is used, AVERAGE when there are more than one
+	// Counter, Derive and Absolute RRA rows are stored as the rates, the values of the steps are unknown
+
+	var doc, step, last_update, err = xml_decode(r)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return xml_import(doc, step, last_update)
+
+}
+
+func xml_import(doc *xml_rrd, step time.Duration, last_update time.Time) (*Rrd, error) {
+
+	// the Rrd of the RRA with the fewest pdp_per_row
+
+	var best = -1
+	var best_pdp uint64
+
+	for l := range doc.Rra {
+
+		if _, supported := xml_cf_consolidation(doc.Rra[l].Cf); supported == false {
+			continue
+		}
+
+		pdp, err := xml_uint(doc.Rra[l].PdpPerRow)
+		if (err != nil) {
+			return nil, err
+		}
+
+		if (best == -1 || pdp < best_pdp || (pdp == best_pdp && strings.TrimSpace(doc.Rra[l].Cf) == "AVERAGE")) {
+			best = l
+			best_pdp = pdp
+		}
+
+	}
+
+	if (best == -1) {
+		return nil, errors.New("the rrdtool dump has no RRA with AVERAGE, MIN, MAX or LAST")
+	}
+
+	var rra = doc.Rra[best]
+
+	var rrd_out Rrd
+	var err = xml_definitions(doc, &rrd_out)
+	if (err != nil) {
+		return nil, err
+	}
+
+	rrd_out.Interval = step * time.Duration(best_pdp)
+	rrd_out.Consolidation, _ = xml_cf_consolidation(rra.Cf)
+
+	if (len(rra.Rows) == 0) {
+		return nil, errors.New("the rrdtool dump RRA has no rows")
+	}
+
+	// the last step is the step that contains lastupdate, the rows are before it
+	rrd_out.TotalSteps = uint64(len(rra.Rows)) + 1
+
+	var current = xml_align(last_update, rrd_out.Interval)
+
+	err = xml_fill(&rrd_out, rra.Rows, current, doc, best_pdp == 1, last_update)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return &rrd_out, nil
+
+}
+
+func ImportXMLSet(r io.Reader) (*RrdSet, error) {
+
+	// read a rrdtool dump XML document into a RrdSet with an Archive for each RRA
+	// the primary Rrd is the RRA with pdp_per_row 1 and AVERAGE when there is one
+
+	var doc, step, last_update, err = xml_decode(r)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return xml_import_set(doc, step, last_update)
+
+}
+
+func xml_import_set(doc *xml_rrd, step time.Duration, last_update time.Time) (*RrdSet, error) {
+
+	// the RrdSet with an Archive for each RRA
+	// an RRA with a consolidation function that is not supported is skipped like ImportXML
+
+	var archives []Archive
+	var rras []xml_rra
+	var primary_rra = -1
+
+	for l := range doc.Rra {
+
+		var cf, supported = xml_cf_consolidation(doc.Rra[l].Cf)
+		if (supported == false) {
+			continue
+		}
+
+		pdp, err := xml_uint(doc.Rra[l].PdpPerRow)
+		if (err != nil) {
+			return nil, err
+		}
+
+		xff, err := xml_value(doc.Rra[l].Xff)
+		if (err != nil) {
+			return nil, err
+		}
+
+		if (pdp == 1 && (primary_rra == -1 || cf == Average)) {
+			primary_rra = len(rras)
+		}
+
+		archives = append(archives, Archive{Steps: pdp, Rows: uint64(len(doc.Rra[l].Rows)), Consolidation: cf, Xff: xff})
+		rras = append(rras, doc.Rra[l])
+
+	}
+
+	if (len(rras) == 0) {
+		return nil, errors.New("the rrdtool dump has no RRA with AVERAGE, MIN, MAX or LAST")
+	}
+
+	var data_type, err = xml_data_type(doc)
+	if (err != nil) {
+		return nil, err
+	}
+
+	var set = NewRrdSet(step, data_type, archives...)
+	var primaryPtr = &(*set).Primary
+
+	err = xml_definitions(doc, primaryPtr)
+	if (err != nil) {
+		return nil, err
+	}
+
+	// the primary Rrd ends with the step that contains lastupdate
+	var current = xml_align(last_update, step)
+
+	var rows []xml_row
+	if (primary_rra != -1) {
+		rows = rras[primary_rra].Rows
+	}
+
+	if (uint64(len(rows)) > (*primaryPtr).TotalSteps - 1) {
+		rows = rows[uint64(len(rows)) - ((*primaryPtr).TotalSteps - 1):]
+	}
+
+	err = xml_fill(primaryPtr, rows, current, doc, true, last_update)
+	if (err != nil) {
+		return nil, err
+	}
+
+	// archive steps are counted from 1970 like rrdtool
+	var origin = time.Unix(0, 0)
+	var next_step = current
+	(*set).Origin = &origin
+	(*set).NextStep = &next_step
+
+	for l := range (*set).Archives {
+
+		var arc = (*set).Archives[l]
+		var rra = rras[l]
+
+		// the archive steps before the archive step that contains lastupdate
+		var archive_current = xml_align(last_update, arc.Rrd.Interval)
+
+		if (len(rra.Rows) > 0) {
+
+			var first = archive_current.Add(-arc.Rrd.Interval * time.Duration(len(rra.Rows)))
+			arc.Rrd.FirstUpdateTs = &first
+			arc.Rrd.LastUpdate = archive_current.Add(-arc.Rrd.Interval)
+			arc.Rrd.MinimumDataPoints = uint64(len(doc.Ds))
+			arc.Rrd.CurrentAvgCount = 1
+			reset_storage(&arc.Rrd, len(doc.Ds))
+
+			for n := range rra.Rows {
+
+				var values, err = xml_row_values(rra.Rows[n], len(doc.Ds))
+				if (err != nil) {
+					return nil, err
+				}
+
+				for e := range values {
+					arc.Rrd.SetValue(uint64(n), e, values[e])
+				}
+
+			}
+
+		}
+
+		// the consolidation of the archive step that is not complete
+		var elapsed = uint64(current.Sub(archive_current) / step)
+
+		if (elapsed == 0) {
+			continue
+		}
+
+		arc.AccStart = &archive_current
+		arc.Acc = new_series(uint64(len(doc.Ds)))
+		arc.AccKnown = make([]uint64, len(doc.Ds))
+
+		for e := range rra.CdpPrep {
+
+			if (e >= len(doc.Ds)) {
+				break
+			}
+
+			value, err := xml_value(rra.CdpPrep[e].Value)
+			if (err != nil) {
+				return nil, err
+			}
+
+			unknown, err := xml_uint(rra.CdpPrep[e].UnknownDatapoints)
+			if (err != nil) {
+				return nil, err
+			}
+
+			if (math.IsNaN(value) || unknown >= elapsed) {
+				continue
+			}
+
+			arc.AccKnown[e] = elapsed - unknown
+			arc.Acc[e] = value
+
+			if (arc.Consolidation == Average) {
+				// rrdtool stores the sum of the primary steps
+				arc.Acc[e] = value / float64(arc.AccKnown[e])
+			}
+
+		}
+
+	}
+
+	return set, nil
+
+}
+
+func xml_decode(r io.Reader) (*xml_rrd, time.Duration, time.Time, error) {
+
+	// decode and validate the rrdtool dump
+
+	var doc xml_rrd
+
+	var err = xml.NewDecoder(r).Decode(&doc)
+	if (err != nil) {
+		return nil, 0, time.Time{}, err
+	}
+
+	step, err := xml_uint(doc.Step)
+	if (err != nil || step == 0) {
+		return nil, 0, time.Time{}, errors.New("the rrdtool dump step is not valid")
+	}
+
+	last_update, err := strconv.ParseInt(strings.TrimSpace(doc.LastUpdate), 10, 64)
+	if (err != nil) {
+		return nil, 0, time.Time{}, errors.New("the rrdtool dump lastupdate is not valid")
+	}
+
+	if (len(doc.Ds) == 0) {
+		return nil, 0, time.Time{}, errors.New("the rrdtool dump has no data sources")
+	}
+
+	return &doc, time.Duration(step) * time.Second, time.Unix(last_update, 0), nil
+
+}
+
+func xml_data_type(doc *xml_rrd) (uint8, error) {
+
+	// return the DataType of the data sources, a Rrd has one DataType
+
+	var data_type = -1
+
+	for e := range doc.Ds {
+
+		var t uint8
+
+		switch strings.TrimSpace(doc.Ds[e].Type) {
+			case "GAUGE":
+				t = Gauge
+			case "COUNTER", "DCOUNTER":
+				t = Counter
+			case "DERIVE", "DDERIVE":
+				t = Derive
+			case "ABSOLUTE":
+				t = Absolute
+			default:
+				return 0, errors.New("the rrdtool data source type " + strings.TrimSpace(doc.Ds[e].Type) + " is not supported")
+		}
+
+		if (data_type != -1 && int(t) != data_type) {
+			return 0, errors.New("the rrdtool data sources have more than one type, a Rrd has one DataType")
+		}
+
+		data_type = int(t)
+
+	}
+
+	return uint8(data_type), nil
+
+}
+
+func xml_definitions(doc *xml_rrd, rrdPtr *Rrd) (error) {
+
+	// set the DataType and DataSources of the Rrd from the rrdtool data sources
+
+	var data_type, err = xml_data_type(doc)
+	if (err != nil) {
+		return err
+	}
+
+	(*rrdPtr).DataType = data_type
+	(*rrdPtr).MinimumDataPoints = uint64(len(doc.Ds))
+	(*rrdPtr).DataSources = make([]DataSource, len(doc.Ds))
+
+	for e := range doc.Ds {
+
+		var ds = &(*rrdPtr).DataSources[e]
+		(*ds).Name = strings.TrimSpace(doc.Ds[e].Name)
+
+		heartbeat, err := xml_uint(doc.Ds[e].MinimalHeartbeat)
+		if (err != nil) {
+			return err
+		}
+		(*ds).Heartbeat = time.Duration(heartbeat) * time.Second
+
+		min, err := xml_value(doc.Ds[e].Min)
+		if (err != nil) {
+			return err
+		}
+		if (math.IsNaN(min) == false) {
+			(*ds).Min = &min
+		}
+
+		max, err := xml_value(doc.Ds[e].Max)
+		if (err != nil) {
+			return err
+		}
+		if (math.IsNaN(max) == false) {
+			(*ds).Max = &max
+		}
+
+	}
+
+	return nil
+
+}
+
+func xml_fill(rrdPtr *Rrd, rows []xml_row, current time.Time, doc *xml_rrd, pdp bool, last_update time.Time) (error) {
+
+	// store rows in the steps before current and the PDP of the data sources in the step at current
+	// the Rrd has TotalSteps, rows that do not fit are not stored
+	// pdp is false when the step of the Rrd is not the rrdtool step
+
+	var data_points = len(doc.Ds)
+
+	var first = current.Add(-(*rrdPtr).Interval * time.Duration((*rrdPtr).TotalSteps - 1))
+	(*rrdPtr).FirstUpdateTs = &first
+	(*rrdPtr).LastUpdate = last_update
+	(*rrdPtr).CurrentAvgCount = 1
+	(*rrdPtr).Head = 0
+	reset_storage(rrdPtr, data_points)
+
+	var offset = (*rrdPtr).TotalSteps - 1 - uint64(len(rows))
+
+	for n := range rows {
+
+		var values, err = xml_row_values(rows[n], data_points)
+		if (err != nil) {
+			return err
+		}
+
+		for e := range values {
+			xml_set(rrdPtr, offset + uint64(n), e, values[e])
+		}
+
+	}
+
+	var current_step = (*rrdPtr).TotalSteps - 1
+	var elapsed = last_update.Sub(current).Seconds()
+
+	(*rrdPtr).LastUpdateDataPoint = make([]*float64, data_points)
+
+	for e := range doc.Ds {
+
+		last_ds, err := xml_value(doc.Ds[e].LastDs)
+		if (err != nil) {
+			return err
+		}
+
+		if (math.IsNaN(last_ds) == false) {
+			(*rrdPtr).LastUpdateDataPoint[e] = &last_ds
+		}
+
+		if ((*rrdPtr).DataType == Counter || (*rrdPtr).DataType == Derive) {
+			// the rate of the next update is calculated from last_ds
+			(*rrdPtr).SetValue(current_step, e, last_ds)
+		}
+
+		if (pdp == false) {
+			continue
+		}
+
+		if ((*rrdPtr).DataType == Gauge && math.IsNaN(last_ds) == false) {
+			// the value of the update at lastupdate, the PDP has no known seconds when lastupdate is the start of the step
+			(*rrdPtr).SetValue(current_step, e, last_ds)
+		}
+
+		value, err := xml_value(doc.Ds[e].Value)
+		if (err != nil) {
+			return err
+		}
+
+		unknown_sec, err := xml_uint(doc.Ds[e].UnknownSec)
+		if (err != nil) {
+			return err
+		}
+
+		var known_sec = elapsed - float64(unknown_sec)
+
+		if (known_sec > 0 && math.IsNaN(value) == false) {
+			// the PDP is the value or rate multiplied by the known seconds
+			xml_set(rrdPtr, current_step, e, value / known_sec)
+		}
+
+	}
+
+	if ((*rrdPtr).DataType == Counter || (*rrdPtr).DataType == Derive) {
+		xml_counters(rrdPtr, current_step)
+	}
+
+	return nil
+
+}
+
+func xml_counters(rrdPtr *Rrd, current_step uint64) {
+
+	// the dump has the rates of the rows, the counters before current_step are calculated back from last_ds with the rates
+	// each counter is at the time within its step of lastupdate, a Counter that was reset or wrapped is not calculated before it
+
+	for e := 0; e < (*rrdPtr).DataPoints(); e++ {
+
+		for n := current_step; n > 0; n-- {
+
+			var counter, known = (*rrdPtr).Value(n, e)
+			var rate, rate_known = (*rrdPtr).Rate(n, e)
+			if (known == false || rate_known == false) {
+				break
+			}
+
+			var previous = counter - rate * (*rrdPtr).Interval.Seconds()
+			if ((*rrdPtr).DataType == Counter && previous < 0) {
+				break
+			}
+
+			(*rrdPtr).SetValue(n - 1, e, previous)
+
+		}
+
+	}
+
+}
+
+func xml_set(rrdPtr *Rrd, step uint64, e int, v float64) {
+
+	// store a rrdtool value of step, Counter, Derive and Absolute values are rates
+
+	if ((*rrdPtr).DataType == Gauge) {
+		(*rrdPtr).SetValue(step, e, v)
+		return
+	}
+
+	(*rrdPtr).SetRate(step, e, v)
+
+	if ((*rrdPtr).DataType == Absolute) {
+		// the value of an Absolute step is the amount within the step
+		(*rrdPtr).SetValue(step, e, v * (*rrdPtr).Interval.Seconds())
+	}
+
+}
+
+func xml_row_values(row xml_row, data_points int) ([]float64, error) {
+
+	var values = make([]float64, data_points)
+
+	for e := range values {
+
+		if (e >= len(row.V)) {
+			values[e] = math.NaN()
+			continue
+		}
+
+		var v, err = xml_value(row.V[e])
+		if (err != nil) {
+			return nil, err
+		}
+
+		values[e] = v
+
+	}
+
+	return values, nil
+
+}
+
+func xml_align(ts time.Time, interval time.Duration) (time.Time) {
+
+	// return the start of the rrdtool step that contains ts, steps are counted from 1970
+
+	return time.Unix(0, ts.UnixNano() / int64(interval) * int64(interval))
+
+}
+
+func xml_step(interval time.Duration) (int64, error) {
+
+	if (interval < time.Second || interval % time.Second != 0) {
+		return 0, errors.New("Interval " + interval.String() + " is not valid for rrdtool, the step is whole seconds")
+	}
+
+	return int64(interval / time.Second), nil
+
+}
+
+func xml_cf(consolidation uint8) (string, error) {
+
+	if (consolidation == Sum) {
+		return "", errors.New("consolidation SUM is not supported by rrdtool")
+	}
+
+	return consolidation_string(consolidation), nil
+
+}
+
+func xml_cf_consolidation(cf string) (uint8, bool) {
+
+	// return the consolidation of a rrdtool consolidation function, false when it is not supported
+
+	switch strings.TrimSpace(cf) {
+		case "AVERAGE":
+			return Average, true
+		case "MIN":
+			return Min, true
+		case "MAX":
+			return Max, true
+		case "LAST":
+			return Last, true
+	}
+
+	return 0, false
+
+}
+
+func xml_float(v float64) (string) {
+
+	if (math.IsNaN(v)) {
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'e', 10, 64)
+
+}
+
+func xml_value(s string) (float64, error) {
+
+	// parse a rrdtool value, U and NaN are unknown
+
+	s = strings.TrimSpace(s)
+
+	if (s == "" || s == "U" || strings.EqualFold(s, "nan")) {
+		return math.NaN(), nil
+	}
+
+	var v, err = strconv.ParseFloat(s, 64)
+	if (err != nil) {
+		return 0, errors.New("the rrdtool value " + s + " is not valid")
+	}
+
+	return v, nil
+
+}
+
+func xml_uint(s string) (uint64, error) {
+
+	s = strings.TrimSpace(s)
+
+	if (s == "") {
+		return 0, nil
+	}
+
+	var v, err = strconv.ParseUint(s, 10, 64)
+	if (err != nil) {
+		return 0, errors.New("the rrdtool value " + s + " is not valid")
+	}
+
+	return v, nil
+
+}
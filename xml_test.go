@@ -0,0 +1,178 @@
+package rrd
+
+import (
+	"math"
+	"time"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func xml_round_trip(t *testing.T, rrdPtr *Rrd) (*Rrd) {
+
+	var b bytes.Buffer
+
+	var err = ExportXML(&b, rrdPtr)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportXML(&b)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	return imported
+
+}
+
+func TestXMLRoundTripGauge(t *testing.T) {
+
+	// LastUpdate is the start of its step, the PDP of the dump has no known seconds
+
+	var r = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: Gauge}
+	var base = time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	for n := 0; n < 8; n++ {
+
+		var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute), []float64{float64(n + 1) * 100, float64(n)}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	check_same_steps(t, &r, xml_round_trip(t, &r))
+
+}
+
+func TestXMLRoundTripCounter(t *testing.T) {
+
+	// each update is within its step, the counters are calculated back from last_ds
+
+	var r = Rrd{Interval: time.Minute, TotalSteps: 8, DataType: Counter}
+	var base = time.Unix(1700000000, 0).Truncate(time.Minute).Add(30 * time.Second)
+
+	for n := 0; n < 8; n++ {
+
+		var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute), []float64{float64(n * n) * 60}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	check_same_steps(t, &r, xml_round_trip(t, &r))
+
+}
+
+func TestXMLRowsAligned(t *testing.T) {
+
+	// the steps start 30 seconds after the rrdtool steps, the rows end at the rrdtool step that contains LastUpdate
+
+	var r = Rrd{Interval: time.Minute, TotalSteps: 4, DataType: Gauge}
+	var base = time.Unix(1700000000, 0).Truncate(time.Minute).Add(30 * time.Second)
+
+	for n := 0; n < 6; n++ {
+
+		var err = UpdateFloatAt(base.Add(time.Duration(n) * time.Minute + 10 * time.Second), []float64{float64(n)}, &r)
+		if (err != nil) {
+			t.Fatal(err)
+		}
+
+	}
+
+	var b bytes.Buffer
+
+	var err = ExportXML(&b, &r)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	var rows = regexp.MustCompile("/ ([0-9]+) --> <row><v>([^<]*)</v>").FindAllStringSubmatch(b.String(), -1)
+	if (len(rows) != 4) {
+		t.Fatalf("%d rows, want 4", len(rows))
+	}
+
+	var current = xml_align(r.LastUpdate, r.Interval)
+
+	for n := range rows {
+
+		// the row before the row of LastUpdate has the value of the step before the step of LastUpdate, the first row was replaced in the ring
+		var want_ts = current.Add(-r.Interval * time.Duration(len(rows) - 1 - n)).Unix()
+		var want_v = xml_float(math.NaN())
+		if (n > 0) {
+			want_v = xml_float(float64(n + 1))
+		}
+
+		ts, _ := strconv.ParseInt(rows[n][1], 10, 64)
+
+		if (ts != want_ts || rows[n][2] != want_v) {
+			t.Errorf("row %d is %d %s, want %d %s", n, ts, rows[n][2], want_ts, want_v)
+		}
+
+		if (ts % 60 != 0) {
+			t.Errorf("row %d at %d is not aligned to the rrdtool step", n, ts)
+		}
+
+	}
+
+}
+
+func TestXMLSetUnsupportedCf(t *testing.T) {
+
+	// an RRA with a consolidation function that is not supported is skipped by ImportXML and ImportXMLSet
+
+	var set = NewRrdSet(time.Minute, Gauge, Archive{Steps: 1, Rows: 8, Consolidation: Average}, Archive{Steps: 4, Rows: 4, Consolidation: Max})
+	var base = time.Unix(1700000000, 0).Truncate(time.Hour)
+
+	update_test_set(t, set, base, map[int]float64{0: 1, 1: 2, 2: 3, 3: 4, 4: 5, 5: 6, 6: 7, 7: 8, 8: 9}, 8)
+
+	var b bytes.Buffer
+
+	var err = ExportXMLSet(&b, set)
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	var hwpredict = "\t<rra>\n\t\t<cf>HWPREDICT</cf>\n\t\t<pdp_per_row>1</pdp_per_row>\n\t\t<database>\n\t\t\t<row><v>NaN</v></row>\n\t\t</database>\n\t</rra>\n"
+	var doc = strings.Replace(b.String(), "\t<!-- Round Robin Archives -->\n", "\t<!-- Round Robin Archives -->\n" + hwpredict, 1)
+
+	want, err := ImportXMLSet(bytes.NewReader(b.Bytes()))
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	imported, err := ImportXMLSet(strings.NewReader(doc))
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	if (len((*imported).Archives) != 2 || (*imported).Archives[0].Consolidation != Average || (*imported).Archives[1].Consolidation != Max) {
+		t.Fatalf("%d archives, want the AVERAGE and MAX archives", len((*imported).Archives))
+	}
+
+	check_same_steps(t, &(*want).Primary, &(*imported).Primary)
+	check_same_steps(t, &(*want).Archives[1].Rrd, &(*imported).Archives[1].Rrd)
+
+	_, err = ImportXML(strings.NewReader(doc))
+	if (err != nil) {
+		t.Fatal(err)
+	}
+
+	// no RRA is left
+	var only = regexp.MustCompile("(?s)\t<rra>.*</rra>\n").ReplaceAllString(doc, hwpredict)
+
+	_, err = ImportXMLSet(strings.NewReader(only))
+	if (err == nil) {
+		t.Error("ImportXMLSet of a dump with only a HWPREDICT RRA is not an error")
+	}
+
+	_, err = ImportXML(strings.NewReader(only))
+	if (err == nil) {
+		t.Error("ImportXML of a dump with only a HWPREDICT RRA is not an error")
+	}
+
+}